@@ -0,0 +1,190 @@
+// Package asm translates between a text assembly of core.Instruction
+// mnemonics and the bytecode run by core.VM.
+//
+// A source holds one instruction per line:
+//
+//	; count down from 3
+//	PUSH 3
+//	loop:
+//	JUMPDEST
+//	PUSH 1
+//	SUB
+//	DUP
+//	PUSH loop
+//	JUMPI
+//
+// PUSH takes a decimal or 0x prefixed hex number, or a label, and is
+// assembled to the narrowest PUSHn that holds it. Labels always take two
+// bytes. PUSH1 to PUSH32 can be used to pick the width explicitly. A label
+// names the offset of the instruction that follows it. Everything after a
+// ';' is a comment.
+package asm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hitenjain14/go-blockchain/core"
+)
+
+// labelSize is the width of the push immediate of a label reference.
+const labelSize = 2
+
+type line struct {
+	num     int
+	instr   core.Instruction
+	operand string
+	value   *big.Int // nil for label operands
+}
+
+// Assemble turns src into bytecode.
+func Assemble(src string) ([]byte, error) {
+	lines := []line{}
+	labels := make(map[string]int)
+
+	// first pass: parse instructions and resolve label offsets
+	pos := 0
+	for i, text := range strings.Split(src, "\n") {
+		num := i + 1
+		if idx := strings.Index(text, ";"); idx >= 0 {
+			text = text[:idx]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) == 1 && strings.HasSuffix(fields[0], ":") {
+			label := strings.TrimSuffix(fields[0], ":")
+			if _, dup := labels[label]; dup {
+				return nil, fmt.Errorf("line %d: label %q redefined", num, label)
+			}
+			labels[label] = pos
+			continue
+		}
+
+		l, err := parseLine(num, fields)
+		if err != nil {
+			return nil, err
+		}
+		pos += 1 + l.instr.PushSize()
+		lines = append(lines, l)
+	}
+
+	// second pass: emit bytecode
+	code := make([]byte, 0, pos)
+	for _, l := range lines {
+		code = append(code, byte(l.instr))
+		if !l.instr.IsPush() {
+			continue
+		}
+
+		v := l.value
+		if v == nil {
+			offset, ok := labels[l.operand]
+			if !ok {
+				return nil, fmt.Errorf("line %d: undefined label %q", l.num, l.operand)
+			}
+			v = big.NewInt(int64(offset))
+		}
+
+		n := l.instr.PushSize()
+		if (v.BitLen()+7)/8 > n {
+			return nil, fmt.Errorf("line %d: %s does not fit in %s", l.num, l.operand, l.instr)
+		}
+		code = append(code, v.FillBytes(make([]byte, n))...)
+	}
+
+	return code, nil
+}
+
+func parseLine(num int, fields []string) (line, error) {
+	name := strings.ToUpper(fields[0])
+	l := line{num: num}
+
+	if name != "PUSH" {
+		instr, ok := core.ParseInstruction(name)
+		if !ok {
+			return l, fmt.Errorf("line %d: unknown instruction %q", num, fields[0])
+		}
+		l.instr = instr
+	}
+
+	if name != "PUSH" && !l.instr.IsPush() {
+		if len(fields) != 1 {
+			return l, fmt.Errorf("line %d: %s takes no operand", num, l.instr)
+		}
+		return l, nil
+	}
+
+	if len(fields) != 2 {
+		return l, fmt.Errorf("line %d: %s takes exactly one operand", num, name)
+	}
+	l.operand = fields[1]
+
+	if v, ok := new(big.Int).SetString(l.operand, 0); ok {
+		if v.Sign() < 0 || v.BitLen() > 256 {
+			return l, fmt.Errorf("line %d: push operand %s out of range", num, l.operand)
+		}
+		l.value = v
+	} else if !isLabel(l.operand) {
+		return l, fmt.Errorf("line %d: invalid push operand %q", num, l.operand)
+	}
+
+	if name == "PUSH" {
+		n := labelSize
+		if l.value != nil {
+			n = (l.value.BitLen() + 7) / 8
+			if n == 0 {
+				n = 1
+			}
+		}
+		instr, err := core.PushInstruction(n)
+		if err != nil {
+			return l, fmt.Errorf("line %d: %v", num, err)
+		}
+		l.instr = instr
+	}
+
+	return l, nil
+}
+
+func isLabel(s string) bool {
+	for i, r := range s {
+		letter := r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Disassemble returns an annotated listing of code with one instruction
+// per line: its offset, its raw bytes and its assembly.
+func Disassemble(code []byte) string {
+	b := &strings.Builder{}
+
+	for pos := 0; pos < len(code); {
+		instr, operand, size := core.DecodeInstruction(code, pos)
+
+		asm := instr.String()
+		if instr.IsPush() {
+			asm = fmt.Sprintf("%s 0x%s", instr, hex.EncodeToString(operand))
+			if len(operand) < instr.PushSize() {
+				asm += " (truncated)"
+			}
+		}
+
+		end := pos + size
+		if end > len(code) {
+			end = len(code)
+		}
+		fmt.Fprintf(b, "%04x  %-12s  %s\n", pos, hex.EncodeToString(code[pos:end]), asm)
+
+		pos += size
+	}
+
+	return b.String()
+}
@@ -0,0 +1,73 @@
+package asm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hitenjain14/go-blockchain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssemble(t *testing.T) {
+	code, err := Assemble("PUSH 1\nPUSH 0x02 ; two\n\nadd\n")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x60, 0x01, 0x60, 0x02, 0x0b}, code)
+
+	vm := core.NewVM(code, nil, 1000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, "[3]", fmt.Sprint(vm.Stack()))
+}
+
+func TestAssemblePushWidth(t *testing.T) {
+	code, err := Assemble("PUSH 0x0100\nPUSH3 1\nPUSH 0")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x61, 0x01, 0x00, 0x62, 0x00, 0x00, 0x01, 0x60, 0x00}, code)
+}
+
+func TestAssembleLabels(t *testing.T) {
+	src := `
+	PUSH 3
+loop:
+	JUMPDEST
+	PUSH 1
+	SUB
+	DUP
+	PUSH loop
+	JUMPI
+`
+	code, err := Assemble(src)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{byte(core.InstrPush1 + 1), 0x00, 0x02}, code[7:10])
+
+	vm := core.NewVM(code, nil, 1000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, "[0]", fmt.Sprint(vm.Stack()))
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, src := range []string{
+		"FOO",
+		"PUSH",
+		"PUSH -1",
+		"PUSH1 256",
+		"PUSH 0x1" + fmt.Sprintf("%064x", 0),
+		"PUSH nowhere",
+		"PUSH 1x",
+		"ADD 1",
+		"a:\na:",
+	} {
+		_, err := Assemble(src)
+		assert.NotNil(t, err, src)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	code := []byte{0x60, 0x01, 0x61, 0x00, 0x02, 0x0b, 0xff, 0x62, 0x01}
+
+	want := "0000  6001          PUSH1 0x01\n" +
+		"0002  610002        PUSH2 0x0002\n" +
+		"0005  0b            ADD\n" +
+		"0006  ff            INVALID(0xff)\n" +
+		"0007  6201          PUSH3 0x01 (truncated)\n"
+	assert.Equal(t, want, Disassemble(code))
+}
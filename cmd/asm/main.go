@@ -0,0 +1,52 @@
+// Command asm assembles VM programs into hex encoded bytecode and
+// disassembles bytecode back into an annotated listing.
+//
+//	asm program.asm       print the bytecode of program.asm
+//	asm -d program.hex    print the listing of hex encoded bytecode
+//
+// Input is read from stdin when no file is given.
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hitenjain14/go-blockchain/asm"
+)
+
+func main() {
+	disassemble := flag.Bool("d", false, "disassemble hex encoded bytecode")
+	flag.Parse()
+
+	input, err := readInput(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *disassemble {
+		code, err := hex.DecodeString(strings.Join(strings.Fields(string(input)), ""))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(asm.Disassemble(code))
+		return
+	}
+
+	code, err := asm.Assemble(string(input))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(hex.EncodeToString(code))
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
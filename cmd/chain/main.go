@@ -0,0 +1,234 @@
+// Command chain runs offline operations on the block store of a node.
+//
+//	chain export -datadir DIR -out FILE   write the chain to an archive
+//	chain import -datadir DIR -in FILE    validate and add archived blocks
+//	chain verify-chain -datadir DIR       check the stored chain for corruption
+//	chain genesis-hash -genesis FILE      print the hash of a genesis file
+//
+// import takes an optional -genesis FILE, which the archive must start
+// with and whose allocations and rules the imported chain starts from. It
+// is needed for a chain started from a genesis file, unless the store
+// already keeps that genesis. verify-chain takes one to check a genesis
+// block made from a genesis file against, and otherwise uses the stored
+// one.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/core"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "import":
+		err = runImport(os.Args[2:])
+	case "verify-chain":
+		err = runVerifyChain(os.Args[2:])
+	case "genesis-hash":
+		err = runGenesisHash(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chain export|import|verify-chain|genesis-hash [flags]")
+	os.Exit(2)
+}
+
+func newLogger() log.Logger {
+	return log.NewLogfmtLogger(os.Stderr)
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dataDir := fs.String("datadir", "", "directory of the block store")
+	out := fs.String("out", "", "archive file to write")
+	fs.Parse(args)
+
+	if *dataDir == "" || *out == "" {
+		return fmt.Errorf("export needs -datadir and -out")
+	}
+
+	// read-only, so that exporting never repairs or prunes the directory
+	// of a node that may still be running
+	store, err := core.NewFileStore(*dataDir, core.FileStoreOpts{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := core.ExportStorage(store, f)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	fmt.Printf("exported %d blocks to %s\n", n, *out)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dataDir := fs.String("datadir", "", "directory of the block store")
+	in := fs.String("in", "", "archive file to read")
+	genesisFile := fs.String("genesis", "", "genesis file of the network")
+	fs.Parse(args)
+
+	if *dataDir == "" || *in == "" {
+		return fmt.Errorf("import needs -datadir and -in")
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ar, err := core.NewArchiveReader(f)
+	if err != nil {
+		return err
+	}
+	genesis, err := ar.Next()
+	if err != nil {
+		return fmt.Errorf("reading genesis block: %w", err)
+	}
+
+	store, err := core.NewFileStore(*dataDir, core.FileStoreOpts{Sync: core.SyncNever})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var bc *core.Blockchain
+	if *genesisFile != "" {
+		g, err := core.LoadGenesis(*genesisFile)
+		if err != nil {
+			return err
+		}
+		if hash := genesis.Hash(core.BlockHasher{}); hash != g.Hash() {
+			return fmt.Errorf("archive starts with genesis block %s, not %s of %s", hash, g.Hash(), *genesisFile)
+		}
+		bc, err = core.NewBlockchainFromGenesis(newLogger(), store, g)
+	} else {
+		bc, err = core.NewBlockchainWithStorage(newLogger(), store, genesis)
+	}
+	if err != nil {
+		return err
+	}
+
+	n, err := bc.Import(ar)
+	if err != nil {
+		return err
+	}
+	// the store never synced the imported blocks, closing it does
+	if err := store.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d blocks, chain height is %d\n", n, bc.Height())
+	return nil
+}
+
+func runVerifyChain(args []string) error {
+	fs := flag.NewFlagSet("verify-chain", flag.ExitOnError)
+	dataDir := fs.String("datadir", "", "directory of the block store")
+	genesisFile := fs.String("genesis", "", "genesis file of the network")
+	fs.Parse(args)
+
+	if *dataDir == "" {
+		return fmt.Errorf("verify-chain needs -datadir")
+	}
+
+	var genesis *core.Genesis
+	if *genesisFile != "" {
+		g, err := core.LoadGenesis(*genesisFile)
+		if err != nil {
+			return err
+		}
+		genesis = g
+	}
+
+	store, err := core.NewFileStore(*dataDir, core.FileStoreOpts{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := core.VerifyChain(store, genesis)
+	var corrupt *core.CorruptBlockError
+	if errors.As(err, &corrupt) {
+		return fmt.Errorf("chain is corrupt from height %d on: %w", corrupt.Height, corrupt.Err)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("verified %d blocks\n", report.Blocks)
+	if len(report.HeaderOnly) > 0 {
+		fmt.Printf("pruned blocks %s only had their header checked\n", heightRanges(report.HeaderOnly))
+	}
+	return nil
+}
+
+// heightRanges returns ascending heights as a list of ranges, like 0-99,150.
+func heightRanges(heights []uint32) string {
+	ranges := []string{}
+	for i := 0; i < len(heights); {
+		j := i
+		for j+1 < len(heights) && heights[j+1] == heights[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprint(heights[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", heights[i], heights[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ",")
+}
+
+func runGenesisHash(args []string) error {
+	fs := flag.NewFlagSet("genesis-hash", flag.ExitOnError)
+	genesisFile := fs.String("genesis", "", "genesis file of the network")
+	fs.Parse(args)
+
+	if *genesisFile == "" {
+		return fmt.Errorf("genesis-hash needs -genesis")
+	}
+
+	g, err := core.LoadGenesis(*genesisFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(g.Hash())
+	return nil
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A chain archive starts with archiveMagic and a 2 byte big-endian
+// format version, followed by one record per block in height order.
+const (
+	archiveMagic   = "GOCHAIN\x00"
+	archiveVersion = 1
+
+	// maxArchiveRecord bounds the size of a single block in an archive.
+	maxArchiveRecord = 64 << 20
+)
+
+type ArchiveWriter struct {
+	w *bufio.Writer
+}
+
+// NewArchiveWriter writes the archive header to w.
+func NewArchiveWriter(w io.Writer) (*ArchiveWriter, error) {
+	bw := bufio.NewWriter(w)
+
+	header := make([]byte, len(archiveMagic)+2)
+	copy(header, archiveMagic)
+	binary.BigEndian.PutUint16(header[len(archiveMagic):], archiveVersion)
+	if _, err := bw.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &ArchiveWriter{w: bw}, nil
+}
+
+func (a *ArchiveWriter) Write(b *Block) error {
+	record, err := encodeRecord(b)
+	if err != nil {
+		return err
+	}
+	_, err = a.w.Write(record)
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (a *ArchiveWriter) Flush() error {
+	return a.w.Flush()
+}
+
+type ArchiveReader struct {
+	r       *bufio.Reader
+	version uint16
+}
+
+// NewArchiveReader reads and checks the archive header of r.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(archiveMagic)+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("reading archive header: %w", err)
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, fmt.Errorf("not a chain archive")
+	}
+
+	version := binary.BigEndian.Uint16(header[len(archiveMagic):])
+	if version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", version)
+	}
+
+	return &ArchiveReader{r: br, version: version}, nil
+}
+
+// Next returns the next block of the archive, or io.EOF after the last
+// one.
+func (a *ArchiveReader) Next() (*Block, error) {
+	return readNextRecord(a.r, maxArchiveRecord)
+}
+
+// Export writes every block from genesis to the tip to w as an archive
+// and returns the number of blocks written.
+func (bc *Blockchain) Export(w io.Writer) (int, error) {
+	return exportBlocks(bc.store, bc.Height(), w)
+}
+
+// ExportStorage writes every block of s to w as an archive and returns
+// the number of blocks written. Unlike Export it needs no Blockchain, so
+// no transaction is run to get the blocks out of a store.
+func ExportStorage(s Storage, w io.Writer) (int, error) {
+	return exportBlocks(s, math.MaxUint32, w)
+}
+
+func exportBlocks(s Storage, to uint32, w io.Writer) (int, error) {
+	aw, err := NewArchiveWriter(w)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	err = s.Iterate(0, to, func(b *Block) error {
+		if err := aw.Write(b); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+
+	return n, aw.Flush()
+}
+
+// Import validates and adds every block of ar to the chain and returns
+// the number of blocks added. Blocks the chain already holds must match
+// the archived ones and are skipped.
+func (bc *Blockchain) Import(ar *ArchiveReader) (int, error) {
+	n := 0
+	for {
+		b, err := ar.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("reading archived block after %d imported: %w", n, err)
+		}
+
+		if b.Height <= bc.Height() {
+			have, err := bc.GetHeader(b.Height)
+			if err != nil {
+				return n, err
+			}
+			if (BlockHasher{}).Hash(have) != b.Hash(BlockHasher{}) {
+				return n, fmt.Errorf("archived block with %d height doesn't match the chain", b.Height)
+			}
+			continue
+		}
+
+		if err := bc.AddBlock(b); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	for height := uint32(1); height <= 3; height++ {
+		assert.Nil(t, bc.AddBlock(randomBlock(t, height, getPrevBlockHash(t, bc, height))))
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := bc.Export(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+
+	ar, err := NewArchiveReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	genesis, err := ar.Next()
+	assert.Nil(t, err)
+
+	imported, err := NewBlockchain(log.NewLogfmtLogger(os.Stderr), genesis)
+	assert.Nil(t, err)
+	n, err = imported.Import(ar)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, uint32(3), imported.Height())
+
+	for height := uint32(0); height <= 3; height++ {
+		want, _ := bc.GetHeader(height)
+		got, _ := imported.GetHeader(height)
+		assert.Equal(t, BlockHasher{}.Hash(want), BlockHasher{}.Hash(got))
+	}
+
+	// importing again adds nothing
+	ar, err = NewArchiveReader(bytes.NewReader(buf.Bytes()))
+	assert.Nil(t, err)
+	n, err = imported.Import(ar)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestExportStorage(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), s, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+	for height := uint32(1); height <= 3; height++ {
+		assert.Nil(t, bc.AddBlock(randomBlock(t, height, getPrevBlockHash(t, bc, height))))
+	}
+
+	want := &bytes.Buffer{}
+	_, err = bc.Export(want)
+	assert.Nil(t, err)
+
+	// the directory can be exported read-only while the chain has it open
+	ro := newFileStore(t, dir, FileStoreOpts{ReadOnly: true})
+	defer ro.Close()
+
+	got := &bytes.Buffer{}
+	n, err := ExportStorage(ro, got)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, want.Bytes(), got.Bytes())
+	assert.Nil(t, s.Close())
+}
+
+func TestImportValidatesBlocks(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesis, err := bc.GetBlock(0)
+	assert.Nil(t, err)
+
+	buf := &bytes.Buffer{}
+	aw, err := NewArchiveWriter(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, aw.Write(genesis))
+	assert.Nil(t, aw.Write(randomBlock(t, 1, types.RandomHash())))
+	assert.Nil(t, aw.Flush())
+
+	ar, err := NewArchiveReader(buf)
+	assert.Nil(t, err)
+	n, err := bc.Import(ar)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestArchiveReaderRejectsInvalidInput(t *testing.T) {
+	_, err := NewArchiveReader(bytes.NewReader([]byte("not an archive")))
+	assert.NotNil(t, err)
+
+	header := append([]byte(archiveMagic), 0x00, 0x02)
+	_, err = NewArchiveReader(bytes.NewReader(header))
+	assert.NotNil(t, err)
+
+	// a truncated record
+	buf := &bytes.Buffer{}
+	aw, err := NewArchiveWriter(buf)
+	assert.Nil(t, err)
+	assert.Nil(t, aw.Write(randomBlock(t, 0, types.Hash{})))
+	assert.Nil(t, aw.Flush())
+
+	ar, err := NewArchiveReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.Nil(t, err)
+	_, err = ar.Next()
+	assert.NotNil(t, err)
+}
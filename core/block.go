@@ -3,7 +3,7 @@ package core
 import (
 	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
+	"encoding/binary"
 	"fmt"
 	"time"
 
@@ -88,13 +88,16 @@ func (b *Block) Encode(enc Encoder[*Block]) error {
 	return enc.Encode(b)
 }
 
+// Bytes returns the fixed size binary encoding of the header that block
+// hashes are computed from. gob can't be used here, as its output depends
+// on the order in which a process first encoded its types.
 func (h *Header) Bytes() []byte {
 	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	err := enc.Encode(h)
-	if err != nil {
-		panic(err)
-	}
+	binary.Write(buf, binary.LittleEndian, h.Version)
+	buf.Write(h.PrevBlockHash.ToSlice())
+	buf.Write(h.DataHash.ToSlice())
+	binary.Write(buf, binary.LittleEndian, h.Timestamp)
+	binary.Write(buf, binary.LittleEndian, h.Height)
 
 	return buf.Bytes()
 }
@@ -108,10 +111,7 @@ func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
 	buf := &bytes.Buffer{}
 
 	for _, tx := range txx {
-		err = tx.Encode(NewGobTxEncoder(buf))
-		if err != nil {
-			return
-		}
+		buf.Write(tx.Bytes())
 	}
 
 	hash = sha256.Sum256(buf.Bytes())
@@ -119,14 +119,16 @@ func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
 	return
 }
 
-func NewBlockFromPrevHeader(prevHeader *Header, txx []*Transaction) (*Block, error) {
+// NewBlockFromPrevHeader returns a block with txx that follows prevHeader,
+// with the version config sets for its height.
+func NewBlockFromPrevHeader(config *ChainConfig, prevHeader *Header, txx []*Transaction) (*Block, error) {
 	dataHash, err := CalculateDataHash(txx)
 	if err != nil {
 		return nil, err
 	}
 
 	header := &Header{
-		Version:       1,
+		Version:       config.Rules(prevHeader.Height + 1).Version,
 		DataHash:      dataHash,
 		PrevBlockHash: BlockHasher{}.Hash(prevHeader),
 		Timestamp:     time.Now().UnixNano(),
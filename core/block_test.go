@@ -44,7 +44,31 @@ func TestEncodeDecodeBlock(t *testing.T) {
 	assert.Equal(t, b.Header, bDecode.Header)
 }
 
+func TestHeaderBytes(t *testing.T) {
+	h := &Header{
+		Version:       2,
+		PrevBlockHash: types.Hash{1},
+		DataHash:      types.Hash{2},
+		Timestamp:     3,
+		Height:        4,
+	}
+
+	// the layout is fixed, so hashes don't depend on the process computing
+	// them
+	b := h.Bytes()
+	assert.Len(t, b, 80)
+	assert.Equal(t, []byte{2, 0, 0, 0}, b[0:4])
+	assert.Equal(t, byte(1), b[4])
+	assert.Equal(t, byte(2), b[36])
+	assert.Equal(t, []byte{3, 0, 0, 0, 0, 0, 0, 0}, b[68:76])
+	assert.Equal(t, []byte{4, 0, 0, 0}, b[76:80])
+}
+
 func randomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *Block {
+	return signedBlock(t, height, prevBlockHash, randomSignedTransaction(t))
+}
+
+func signedBlock(t *testing.T, height uint32, prevBlockHash types.Hash, txx ...*Transaction) *Block {
 
 	privKey := crypto.GeneratePrivateKey()
 
@@ -55,9 +79,7 @@ func randomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *Block {
 		Height:        height,
 	}
 
-	txx := randomSignedTransaction(t)
-
-	b, err := NewBlock(header, []*Transaction{txx})
+	b, err := NewBlock(header, txx)
 	assert.Nil(t, err)
 	dataHash, err := CalculateDataHash(b.Transactions)
 	assert.Nil(t, err)
@@ -1,34 +1,250 @@
 package core
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"sync"
 
 	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/crypto"
+	"github.com/hitenjain14/go-blockchain/types"
 )
 
 type Blockchain struct {
-	logger    log.Logger
-	store     Storage
-	lock      sync.RWMutex
-	headers   []*Header
+	logger log.Logger
+	store  Storage
+	lock   sync.RWMutex
+	// chain is the canonical chain by height, nodes every known block and
+	// side the blocks off the canonical chain
+	chain     []*blockNode
+	nodes     map[types.Hash]*blockNode
+	side      map[types.Hash]*blockNode
+	addLock   sync.Mutex // serializes adding blocks
 	validator Validator
+	state     *State
+	txIndex   *TxIndex
+	events    eventFeed
+	// validators may sign blocks, anyone when empty
+	validators []crypto.PublicKey
+	config     *ChainConfig
+	// finalityDepth is the number of blocks a reorganization may take off
+	// the chain at most
+	finalityDepth uint32
+	// the state after the last final block is handed to the store every
+	// snapshotInterval blocks, next when that block reaches nextSnapshot,
+	// which is guarded by lock
+	snapshotInterval uint32
+	nextSnapshot     uint32
 }
 
 func NewBlockchain(l log.Logger, genesis *Block) (*Blockchain, error) {
+	return NewBlockchainWithStorage(l, NewMemoryStore(), genesis)
+}
+
+// NewBlockchainWithStorage returns a chain that keeps its blocks in s and
+// starts from the genesis block. It follows the rules of the genesis s
+// keeps, if any, and otherwise those of DefaultChainConfig. A chain
+// started from a genesis file must be created with
+// NewBlockchainFromGenesis, as its block doesn't carry the allocations
+// and rules of the file.
+func NewBlockchainWithStorage(l log.Logger, s Storage, genesis *Block) (*Blockchain, error) {
+	stored, err := storedGenesis(s)
+	if err != nil {
+		return nil, err
+	}
+	hash := genesis.Hash(BlockHasher{})
+	if stored != nil {
+		if stored.Hash() != hash {
+			return nil, fmt.Errorf("store was started from genesis %s, not from genesis block %s", stored.Hash(), hash)
+		}
+		return NewBlockchainFromGenesis(l, s, stored)
+	}
+
+	bc := newBlockchain(l, s, DefaultChainConfig())
+	err = bc.load(genesis)
+
+	return bc, err
+}
+
+// NewBlockchainFromGenesis returns a chain that keeps its blocks in s and
+// starts from the block, validators, allocations and chain config of g.
+// The genesis is kept in s if it can hold it. When resuming, a g without
+// a chain config takes the one kept, and one with a chain config must
+// keep its upgrades and may add later ones.
+func NewBlockchainFromGenesis(l log.Logger, s Storage, g *Genesis) (*Blockchain, error) {
+	stored, err := storedGenesis(s)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil {
+		if stored.Hash() != g.Hash() {
+			return nil, fmt.Errorf("store was started from genesis %s, not from genesis %s", stored.Hash(), g.Hash())
+		}
+		if g.Config == nil {
+			g = stored
+		} else if !g.ChainConfig().extends(stored.ChainConfig()) {
+			return nil, fmt.Errorf("chain config changes upgrades of the chain config the store was started with")
+		}
+	}
+
+	config := g.ChainConfig()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	bc := newBlockchain(l, s, config)
+	bc.validators = g.Validators
+	for addr, storage := range g.Alloc {
+		bc.state.Commit(addr, storage)
+	}
+	if err := bc.load(g.Block()); err != nil {
+		return bc, err
+	}
+
+	// the genesis is kept once its block is stored, and again when it
+	// adds upgrades
+	if gs, ok := s.(GenesisStorage); ok && (stored == nil || !stored.ChainConfig().extends(config)) {
+		if err := gs.PutGenesis(g); err != nil {
+			return bc, err
+		}
+	}
+
+	return bc, nil
+}
+
+func newBlockchain(l log.Logger, s Storage, config *ChainConfig) *Blockchain {
 	bc := &Blockchain{
-		headers: []*Header{},
-		store:   NewMemoryStore(),
+		config:  config,
+		chain:   []*blockNode{},
+		nodes:   make(map[types.Hash]*blockNode),
+		side:    make(map[types.Hash]*blockNode),
+		store:   s,
 		logger:  l,
+		state:   NewState(),
+		txIndex: NewTxIndex(),
+
+		finalityDepth:    defaultFinalityDepth,
+		snapshotInterval: defaultSnapshotInterval,
 	}
 	bc.validator = NewBlockValidator(bc)
-	err := bc.addBlockWithoutValidation(genesis)
-
-	return bc, err
+	return bc
 }
 
-func (bc *Blockchain) SetStorage(s Storage) {
-	bc.store = s
+// load resumes the chain from the blocks already in the store, or starts
+// it with genesis when the store is empty. A store that keeps the state
+// provides it together with the receipts of every block, so nothing is
+// run again. Otherwise the stored blocks are run again, and when blocks
+// were pruned the state starts from the store's snapshot and the blocks
+// up to it only contribute their header, so their transactions are
+// neither run again nor have receipts.
+func (bc *Blockchain) load(genesis *Block) error {
+	stored, err := bc.store.GetHeader(0)
+	if errors.Is(err, ErrBlockNotFound) {
+		return bc.addBlockWithoutValidation(genesis)
+	}
+	if err != nil {
+		return err
+	}
+
+	genesisHash := genesis.Hash(BlockHasher{})
+	if hash := (BlockHasher{}).Hash(stored); hash != genesisHash {
+		return fmt.Errorf("stored genesis block %s doesn't match genesis block %s", hash, genesisHash)
+	}
+
+	// a store that keeps the state has it for the last stored block, and
+	// the receipts and state changes of every block
+	kept, keepsState := keptState(bc.store)
+	if keepsState {
+		data, err := kept.state()
+		if err != nil {
+			return fmt.Errorf("reading stored state: %w", err)
+		}
+		bc.state.restore(data)
+	}
+
+	snapshot, err := bc.storedSnapshot()
+	if err != nil {
+		return err
+	}
+	if snapshot != nil {
+		bc.lock.Lock()
+		bc.nextSnapshot = snapshot.Height + bc.snapshotInterval
+		bc.lock.Unlock()
+	}
+	// the snapshot is only needed once blocks were pruned
+	if _, err := bc.store.GetByHeight(0); errors.Is(err, ErrBlockPruned) {
+		if snapshot == nil {
+			return fmt.Errorf("stored blocks are pruned, but there is no state snapshot to start from")
+		}
+		bc.state.restore(snapshot.Data)
+	} else {
+		snapshot = nil
+	}
+
+	var (
+		prev   *Header
+		pruned int
+	)
+	for height := uint32(0); ; height++ {
+		b, err := bc.store.GetByHeight(height)
+		isPruned := errors.Is(err, ErrBlockPruned)
+		if isPruned {
+			var h *Header
+			if h, err = bc.store.GetHeader(height); err == nil {
+				b = &Block{Header: h}
+				pruned++
+			}
+		}
+		if errors.Is(err, ErrBlockNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if prev != nil {
+			if b.Height != prev.Height+1 {
+				return fmt.Errorf("stored block with %d height follows block with %d height", b.Height, prev.Height)
+			}
+			if hash := (BlockHasher{}).Hash(prev); b.PrevBlockHash != hash {
+				return fmt.Errorf("stored block with %d height has invalid previous block hash %s", b.Height, b.PrevBlockHash)
+			}
+		}
+		prev = b.Header
+
+		if keepsState {
+			receipts, undo, err := kept.blockState(height)
+			if err != nil {
+				return err
+			}
+			bc.linkBlock(b, receipts, undo)
+			continue
+		}
+		if snapshot != nil && height <= snapshot.Height {
+			if hash := (BlockHasher{}).Hash(b.Header); height == snapshot.Height && hash != snapshot.Hash {
+				return fmt.Errorf("state snapshot is of block %s, not of stored block %s with %d height", snapshot.Hash, hash, height)
+			}
+			bc.linkBlock(b, nil, nil)
+			continue
+		}
+		if isPruned {
+			return fmt.Errorf("stored block with %d height is pruned past the state snapshot", height)
+		}
+		bc.applyBlock(b)
+	}
+
+	if snapshot != nil && bc.Height() < snapshot.Height {
+		return fmt.Errorf("state snapshot is of block with %d height above the stored tip", snapshot.Height)
+	}
+
+	bc.logger.Log("msg", "loaded chain from storage",
+		"height", bc.Height(),
+		"hash", BlockHasher{}.Hash(prev),
+		"pruned", pruned,
+	)
+
+	return nil
 }
 
 func (bc *Blockchain) SetValidator(v Validator) {
@@ -38,43 +254,291 @@ func (bc *Blockchain) SetValidator(v Validator) {
 func (bc *Blockchain) Height() uint32 {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
-	return uint32(len(bc.headers) - 1)
+	return uint32(len(bc.chain) - 1)
 }
 
+// AddBlock validates b and adds it to the block tree. It becomes the new
+// tip when it extends the canonical chain or makes a side branch longer
+// than it, in which case the chain is reorganized onto that branch.
 func (bc *Blockchain) AddBlock(b *Block) error {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
 
 	if err := bc.validator.ValidateBlock(b); err != nil {
 		return err
 	}
 
-	return bc.addBlockWithoutValidation(b)
+	return bc.insertBlock(b)
+}
+
+// HasBlockHash reports whether the block with the given hash is known,
+// on the canonical chain or on a side branch.
+func (bc *Blockchain) HasBlockHash(hash types.Hash) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	_, ok := bc.nodes[hash]
+	return ok
+}
+
+// Config returns the chain config the chain follows.
+func (bc *Blockchain) Config() *ChainConfig {
+	return bc.config
+}
+
+// GenesisHash returns the hash of the first block, which identifies the
+// network.
+func (bc *Blockchain) GenesisHash() types.Hash {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.chain[0].hash
+}
+
+// IsValidator reports whether key may sign blocks.
+func (bc *Blockchain) IsValidator(key crypto.PublicKey) bool {
+	if len(bc.validators) == 0 {
+		return true
+	}
+	for _, v := range bc.validators {
+		if bytes.Equal(v.ToSlice(), key.ToSlice()) {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns the contract storage built by the programs of all
+// applied transactions.
+func (bc *Blockchain) State() *State {
+	return bc.state
+}
+
+// GetReceipt returns the outcome of running the transaction with the
+// given hash.
+func (bc *Blockchain) GetReceipt(hash types.Hash) (*Receipt, error) {
+	loc, ok := bc.txIndex.Get(hash)
+
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if !ok || int(loc.Height) >= len(bc.chain) || loc.Index >= len(bc.chain[loc.Height].receipts) {
+		return nil, fmt.Errorf("receipt for transaction %s doesn't exist", hash)
+	}
+	return bc.chain[loc.Height].receipts[loc.Index], nil
+}
+
+// GetTransaction returns the transaction with the given hash together
+// with where it was included.
+func (bc *Blockchain) GetTransaction(hash types.Hash) (*Transaction, *TxLocation, error) {
+	loc, ok := bc.txIndex.Get(hash)
+	if !ok {
+		return nil, nil, fmt.Errorf("transaction %s doesn't exist", hash)
+	}
+
+	b, err := bc.store.GetByHeight(loc.Height)
+	if err != nil {
+		return nil, nil, err
+	}
+	if loc.Index >= len(b.Transactions) {
+		return nil, nil, fmt.Errorf("block with %d height has no transaction at index %d", loc.Height, loc.Index)
+	}
+
+	return b.Transactions[loc.Index], &loc, nil
+}
 
+// IncludedTransaction is a transaction together with where it was
+// included.
+type IncludedTransaction struct {
+	Transaction *Transaction
+	Location    TxLocation
 }
 
-func (bc *Blockchain) HasBlock(height uint32) bool {
-	return height <= bc.Height()
+// GetTransactionsBySender returns a page of the transactions sent by
+// addr, newest first. offset is the number of newer transactions to skip
+// and limit the size of the page.
+func (bc *Blockchain) GetTransactionsBySender(addr types.Address, offset, limit int) ([]IncludedTransaction, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("invalid page with offset %d and limit %d", offset, limit)
+	}
+
+	blocks := make(map[uint32]*Block)
+	txx := []IncludedTransaction{}
+	for _, loc := range bc.txIndex.BySender(addr, offset, limit) {
+		b, ok := blocks[loc.Height]
+		if !ok {
+			var err error
+			if b, err = bc.store.GetByHeight(loc.Height); err != nil {
+				return nil, err
+			}
+			blocks[loc.Height] = b
+		}
+		if loc.Index >= len(b.Transactions) {
+			return nil, fmt.Errorf("block with %d height has no transaction at index %d", loc.Height, loc.Index)
+		}
+
+		txx = append(txx, IncludedTransaction{
+			Transaction: b.Transactions[loc.Index],
+			Location:    loc,
+		})
+	}
+
+	return txx, nil
 }
 
 func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
+	ss, ok := keptState(bc.store)
+	if !ok {
+		// the block is stored first: the headers, state and indices are
+		// rebuilt from the store on restart, so they can lag behind it
+		// after a crash but never run ahead of it
+		if err := bc.store.Put(b); err != nil {
+			return err
+		}
+		bc.logAddedBlock(b)
+		bc.applyBlock(b)
+		return nil
+	}
 
-	bc.lock.Lock()
-	bc.headers = append(bc.headers, b.Header)
-	bc.lock.Unlock()
+	// a store that keeps the state gets the block and the state changes
+	// of its transactions in one write, so the transactions run first
+	// and are taken back if the write fails
+	receipts, undo := bc.executeBlock(b)
+	if err := ss.putBlock(b, receipts, bc.stateWrites(b, undo), undo); err != nil {
+		bc.state.revert(undo)
+		return err
+	}
+	bc.logAddedBlock(b)
+	bc.linkBlock(b, receipts, undo)
+	bc.logFailedTransactions(receipts)
+
+	return nil
+}
 
+func (bc *Blockchain) logAddedBlock(b *Block) {
 	bc.logger.Log("msg", "adding new block",
 		"height", b.Height,
 		"hash", b.Hash(&BlockHasher{}),
 		"transactions", len(b.Transactions),
 	)
+}
 
-	return bc.store.Put(b)
+// applyBlock runs the transactions of b and makes it the tip of the
+// canonical chain.
+func (bc *Blockchain) applyBlock(b *Block) {
+	receipts, undo := bc.executeBlock(b)
+	bc.linkBlock(b, receipts, undo)
+	bc.logFailedTransactions(receipts)
 }
 
-func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
-	if height <= bc.Height() {
-		return bc.headers[int(height)], nil
+// executeBlock runs the transactions of b against the state and returns
+// their receipts and the changes that undo them.
+func (bc *Blockchain) executeBlock(b *Block) ([]*Receipt, []stateChange) {
+	bc.state.startJournal()
+	receipts := executeBlock(b, bc.state, bc.config.Rules(b.Height).TxGasLimit)
+	return receipts, bc.state.stopJournal()
+}
+
+// stateWrites returns the values the changes in undo left in the state.
+// The genesis block writes the whole state, which holds the allocations.
+func (bc *Blockchain) stateWrites(b *Block, undo []stateChange) map[types.Address]map[string][]byte {
+	if b.Height == 0 {
+		return bc.state.copy().data
+	}
+
+	writes := make(map[types.Address]map[string][]byte)
+	for _, c := range undo {
+		v, _ := bc.state.Get(c.addr, []byte(c.key))
+		if writes[c.addr] == nil {
+			writes[c.addr] = make(map[string][]byte)
+		}
+		writes[c.addr][c.key] = v
+	}
+	return writes
+}
+
+func (bc *Blockchain) logFailedTransactions(receipts []*Receipt) {
+	for _, r := range receipts {
+		if r.Failed() {
+			bc.logger.Log("msg", "transaction program failed",
+				"hash", r.TxHash,
+				"height", r.Height,
+				"err", r.Err,
+			)
+		}
 	}
+}
+
+// linkBlock makes b, whose transactions were run already, the tip of the
+// canonical chain.
+func (bc *Blockchain) linkBlock(b *Block, receipts []*Receipt, undo []stateChange) {
+	hash := b.Hash(BlockHasher{})
+
 	bc.lock.Lock()
-	defer bc.lock.Unlock()
-	return nil, fmt.Errorf("block with %d height doesn't exist", height)
+	node, ok := bc.nodes[hash]
+	if !ok {
+		node = &blockNode{
+			header: b.Header,
+			hash:   hash,
+			parent: bc.nodes[b.PrevBlockHash],
+		}
+		bc.nodes[hash] = node
+	}
+	node.block = nil
+	node.receipts = receipts
+	node.undo = undo
+	delete(bc.side, hash)
+	bc.chain = append(bc.chain, node)
+	// blocks below the finality depth are never taken off the chain again
+	if final := len(bc.chain) - 1 - int(bc.finalityDepth); final > 0 {
+		bc.chain[final].undo = nil
+	}
+	bc.lock.Unlock()
+
+	bc.txIndex.Add(b)
+}
+
+func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
+	if height > bc.Height() {
+		return nil, fmt.Errorf("block with %d height doesn't exist", height)
+	}
+	return bc.store.GetByHeight(height)
+}
+
+// GetBlockByHash returns the block with the given hash, also when it is
+// on a side branch.
+func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
+	bc.lock.RLock()
+	node, ok := bc.nodes[hash]
+	bc.lock.RUnlock()
+
+	if ok && node.block != nil {
+		return node.block, nil
+	}
+	return bc.store.GetByHash(hash)
+}
+
+// GetHeader returns the header of the canonical block with the given
+// height.
+func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if int(height) >= len(bc.chain) {
+		return nil, fmt.Errorf("block with %d height doesn't exist", height)
+	}
+	return bc.chain[height].header, nil
+}
+
+// GetHeaderByHash returns the header of the block with the given hash,
+// also when it is on a side branch.
+func (bc *Blockchain) GetHeaderByHash(hash types.Hash) (*Header, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	node, ok := bc.nodes[hash]
+	if !ok {
+		return nil, fmt.Errorf("block %s doesn't exist", hash)
+	}
+	return node.header, nil
 }
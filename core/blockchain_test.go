@@ -1,10 +1,14 @@
 package core
 
 import (
+	"fmt"
+	"math/big"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/crypto"
 	"github.com/hitenjain14/go-blockchain/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,10 +22,10 @@ func TestNewBlockchain(t *testing.T) {
 	assert.Equal(t, bc.Height(), uint32(0))
 }
 
-func TestHasBlock(t *testing.T) {
+func TestHasBlockHash(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
-	assert.True(t, bc.HasBlock(0))
-	assert.False(t, bc.HasBlock(1))
+	assert.True(t, bc.HasBlockHash(getPrevBlockHash(t, bc, 1)))
+	assert.False(t, bc.HasBlockHash(types.RandomHash()))
 }
 
 func TestAddBlock(t *testing.T) {
@@ -31,9 +35,17 @@ func TestAddBlock(t *testing.T) {
 	b := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
 	assert.Nil(t, bc.AddBlock(b))
 	assert.Equal(t, bc.Height(), uint32(1))
-	assert.True(t, bc.HasBlock(1))
-	assert.NotNil(t, bc.AddBlock(randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))))
+	assert.True(t, bc.HasBlockHash(b.Hash(BlockHasher{})))
 
+	// a competing block of the same height is kept on a side branch
+	side := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
+	assert.Nil(t, bc.AddBlock(side))
+	assert.True(t, bc.HasBlockHash(side.Hash(BlockHasher{})))
+	header, err := bc.GetHeader(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(BlockHasher{}), BlockHasher{}.Hash(header))
+
+	assert.NotNil(t, bc.AddBlock(b))
 }
 
 func TestAddBlockTooHigh(t *testing.T) {
@@ -59,3 +71,257 @@ func getPrevBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Hash {
 	return BlockHasher{}.Hash(header)
 
 }
+
+func TestAddBlockExecutesTransactions(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	// storage[1] = 42
+	ok := signedTransaction(t, program(push1(42), push1(1), InstrSStore))
+	failed := signedTransaction(t, program(InstrAdd))
+
+	b := signedBlock(t, 1, getPrevBlockHash(t, bc, 1), ok, failed)
+	assert.Nil(t, bc.AddBlock(b))
+
+	v, found := bc.State().Get(ok.From.Address(), wordBytes(big.NewInt(1)))
+	assert.True(t, found)
+	assert.Equal(t, wordBytes(big.NewInt(42)), v)
+
+	receipt, err := bc.GetReceipt(ok.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.False(t, receipt.Failed())
+	assert.Equal(t, uint32(1), receipt.Height)
+	assert.NotZero(t, receipt.GasUsed)
+
+	receipt, err = bc.GetReceipt(failed.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Failed())
+	assert.Contains(t, receipt.Err, "stack underflow")
+
+	_, err = bc.GetReceipt(types.RandomHash())
+	assert.NotNil(t, err)
+}
+
+func TestGetBlock(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	b := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.Nil(t, bc.AddBlock(b))
+
+	fetched, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+
+	fetched, err = bc.GetBlockByHash(b.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), fetched.Height)
+
+	_, err = bc.GetBlock(2)
+	assert.NotNil(t, err)
+	_, err = bc.GetBlockByHash(types.RandomHash())
+	assert.NotNil(t, err)
+}
+
+func TestBlockchainReloadsFromStorage(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewLogfmtLogger(os.Stderr)
+	genesis := randomBlock(t, 0, types.Hash{})
+
+	store := newFileStore(t, dir, FileStoreOpts{})
+	bc, err := NewBlockchainWithStorage(logger, store, genesis)
+	assert.Nil(t, err)
+
+	tx := signedTransaction(t, program(push1(42), push1(1), InstrSStore))
+	assert.Nil(t, bc.AddBlock(signedBlock(t, 1, getPrevBlockHash(t, bc, 1), tx)))
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 2, getPrevBlockHash(t, bc, 2))))
+	assert.Nil(t, store.Close())
+
+	store = newFileStore(t, dir, FileStoreOpts{})
+	defer store.Close()
+	bc, err = NewBlockchainWithStorage(logger, store, genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), bc.Height())
+
+	// transactions were run again
+	_, found := bc.State().Get(tx.From.Address(), wordBytes(big.NewInt(1)))
+	assert.True(t, found)
+	_, err = bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 3, getPrevBlockHash(t, bc, 3))))
+	assert.Equal(t, uint32(3), bc.Height())
+}
+
+func TestBlockchainReloadsPrunedStorage(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewLogfmtLogger(os.Stderr)
+	genesis := randomBlock(t, 0, types.Hash{})
+	opts := FileStoreOpts{SegmentSize: 1, PruneDepth: 1}
+
+	store := newFileStore(t, dir, opts)
+	bc, err := NewBlockchainWithStorage(logger, store, genesis)
+	assert.Nil(t, err)
+	bc.finalityDepth = 1
+	bc.snapshotInterval = 1
+
+	tx := storeTx(t, 7)
+	assert.Nil(t, bc.AddBlock(signedBlock(t, 1, getPrevBlockHash(t, bc, 1), tx)))
+	tx2 := storeTx(t, 8)
+	assert.Nil(t, bc.AddBlock(signedBlock(t, 2, getPrevBlockHash(t, bc, 2), tx2)))
+
+	// the snapshot is of the last final block, without what came after
+	snapshot, err := store.GetSnapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), snapshot.Height)
+	storage := snapshot.Data[tx.From.Address()]
+	assert.Contains(t, storage, string(wordBytes(big.NewInt(7))))
+	assert.NotContains(t, storage, string(wordBytes(big.NewInt(8))))
+
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 3, getPrevBlockHash(t, bc, 3))))
+
+	_, err = bc.GetBlock(1)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	_, _, err = bc.GetTransaction(tx.Hash(TxHasher{}))
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	assert.Nil(t, store.Close())
+
+	store = newFileStore(t, dir, opts)
+	bc, err = NewBlockchainWithStorage(logger, store, genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), bc.Height())
+
+	// the values stored by the pruned transactions come from the snapshot
+	assert.True(t, hasStored(bc, tx, 7))
+	assert.True(t, hasStored(bc, tx2, 8))
+
+	header, err := bc.GetHeader(1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), header.Height)
+
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 4, getPrevBlockHash(t, bc, 4))))
+	assert.Nil(t, store.Close())
+
+	// without the snapshot, the state of the pruned blocks is lost
+	assert.Nil(t, os.Remove(filepath.Join(dir, snapshotFile)))
+	store = newFileStore(t, dir, opts)
+	defer store.Close()
+	_, err = NewBlockchainWithStorage(logger, store, genesis)
+	assert.NotNil(t, err)
+}
+
+type failingStore struct {
+	*MemoryStore
+	fail       bool
+	failBlock  types.Hash // fail to put only this block
+	failRewind bool
+}
+
+func (s *failingStore) Put(b *Block) error {
+	if s.fail || b.Hash(BlockHasher{}) == s.failBlock {
+		return fmt.Errorf("disk full")
+	}
+	return s.MemoryStore.Put(b)
+}
+
+func (s *failingStore) Rewind(height uint32) error {
+	if s.failRewind {
+		return fmt.Errorf("disk gone")
+	}
+	return s.MemoryStore.Rewind(height)
+}
+
+func TestAddBlockStoreFailure(t *testing.T) {
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+
+	store.fail = true
+	tx := signedTransaction(t, program(push1(1)))
+	assert.NotNil(t, bc.AddBlock(signedBlock(t, 1, getPrevBlockHash(t, bc, 1), tx)))
+
+	// the chain didn't move ahead of the store
+	assert.Equal(t, uint32(0), bc.Height())
+	_, err = bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.NotNil(t, err)
+}
+
+func TestBlockchainReloadRejectsOtherGenesis(t *testing.T) {
+	logger := log.NewLogfmtLogger(os.Stderr)
+	store := NewMemoryStore()
+
+	_, err := NewBlockchainWithStorage(logger, store, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+
+	_, err = NewBlockchainWithStorage(logger, store, randomBlock(t, 0, types.Hash{}))
+	assert.NotNil(t, err)
+}
+
+func TestBlockchainReloadRejectsBrokenLinkage(t *testing.T) {
+	logger := log.NewLogfmtLogger(os.Stderr)
+	store := NewMemoryStore()
+
+	genesis := randomBlock(t, 0, types.Hash{})
+	assert.Nil(t, store.Put(genesis))
+	assert.Nil(t, store.Put(randomBlock(t, 1, types.RandomHash())))
+
+	_, err := NewBlockchainWithStorage(logger, store, genesis)
+	assert.NotNil(t, err)
+}
+
+func TestGetTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	txA := randomSignedTransaction(t)
+	txB := signedTransaction(t, []byte("World"))
+	assert.Nil(t, bc.AddBlock(signedBlock(t, 1, getPrevBlockHash(t, bc, 1), txA, txB)))
+
+	tx, loc, err := bc.GetTransaction(txB.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, txB.Data, tx.Data)
+	assert.Equal(t, &TxLocation{Height: 1, Index: 1}, loc)
+
+	_, _, err = bc.GetTransaction(types.RandomHash())
+	assert.NotNil(t, err)
+}
+
+func TestGetTransactionsBySender(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	sent := []*Transaction{}
+	for height := uint32(1); height <= 2; height++ {
+		tx := NewTransaction(types.RandomBytes(8))
+		assert.Nil(t, tx.Sign(privKey))
+		sent = append(sent, tx)
+
+		b := signedBlock(t, height, getPrevBlockHash(t, bc, height), randomSignedTransaction(t), tx)
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	txx, err := bc.GetTransactionsBySender(privKey.PublicKey().Address(), 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, txx, 2)
+	assert.Equal(t, sent[1].Data, txx[0].Transaction.Data)
+	assert.Equal(t, TxLocation{Height: 2, Index: 1}, txx[0].Location)
+	assert.Equal(t, sent[0].Data, txx[1].Transaction.Data)
+
+	txx, err = bc.GetTransactionsBySender(privKey.PublicKey().Address(), 1, 10)
+	assert.Nil(t, err)
+	assert.Len(t, txx, 1)
+	assert.Equal(t, uint32(1), txx[0].Location.Height)
+
+	_, err = bc.GetTransactionsBySender(privKey.PublicKey().Address(), 0, 0)
+	assert.NotNil(t, err)
+}
+
+func TestGetTransactionsBySenderOutOfStep(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	b := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.Nil(t, bc.AddBlock(b))
+
+	// an index entry past the transactions of the stored block
+	tx := randomSignedTransaction(t)
+	bc.txIndex.Add(signedBlock(t, 1, b.PrevBlockHash, randomSignedTransaction(t), tx))
+
+	_, err := bc.GetTransactionsBySender(tx.From.Address(), 0, 10)
+	assert.NotNil(t, err)
+}
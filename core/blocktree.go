@@ -0,0 +1,247 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// blockNode is a block of the block tree. Blocks of the canonical chain
+// live in the store and carry what is needed to take them off the chain
+// again; blocks of side branches keep their body in memory.
+type blockNode struct {
+	header *Header
+	hash   types.Hash
+	parent *blockNode
+
+	// block is the body of a block off the canonical chain, nil while
+	// the block is canonical
+	block *Block
+
+	// receipts of the transactions and the state changes they made,
+	// while the block is canonical
+	receipts []*Receipt
+	undo     []stateChange
+}
+
+// defaultFinalityDepth is the number of blocks a reorganization may take
+// off the chain at most. Branches forking deeper are refused, and side
+// blocks are dropped once they can't win any more.
+const defaultFinalityDepth = 100
+
+// better reports whether a chain ending in n should be preferred over
+// one ending in other. The longest chain wins, and on a tie the chain
+// already followed is kept.
+func (n *blockNode) better(other *blockNode) bool {
+	return n.header.Height > other.header.Height
+}
+
+// insertBlock adds the validated block b to the tree and moves the
+// canonical chain onto it when it makes the better chain.
+func (bc *Blockchain) insertBlock(b *Block) error {
+	hash := b.Hash(BlockHasher{})
+
+	bc.lock.RLock()
+	parent, ok := bc.nodes[b.PrevBlockHash]
+	tip := bc.chain[len(bc.chain)-1]
+	bc.lock.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s of block %s", ErrUnknownParent, b.PrevBlockHash, hash)
+	}
+	if parent == tip {
+		if err := bc.addBlockWithoutValidation(b); err != nil {
+			return err
+		}
+		bc.dropFinalSideBlocks()
+		bc.snapshotState()
+		events := []ChainEvent{BlockAddedEvent{Block: b, Canonical: true}}
+		bc.events.send(append(events, canonicalEvents([]*Block{b}, [][]*Receipt{bc.receiptsOf(hash)})...)...)
+		return nil
+	}
+
+	if depth := tip.header.Height - bc.forkOf(parent).header.Height; depth > bc.finalityDepth {
+		return fmt.Errorf("block %s forks %d blocks below the tip, deeper than the finality depth of %d", hash, depth, bc.finalityDepth)
+	}
+
+	node := &blockNode{
+		header: b.Header,
+		hash:   hash,
+		parent: parent,
+		block:  b,
+	}
+	bc.lock.Lock()
+	bc.nodes[hash] = node
+	bc.side[hash] = node
+	bc.lock.Unlock()
+
+	if !node.better(tip) {
+		bc.logger.Log("msg", "adding side block",
+			"height", b.Height,
+			"hash", hash,
+		)
+		bc.events.send(BlockAddedEvent{Block: b})
+		return nil
+	}
+
+	return bc.reorganize(tip, node)
+}
+
+// receiptsOf returns the receipts of the canonical block with hash.
+func (bc *Blockchain) receiptsOf(hash types.Hash) []*Receipt {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.nodes[hash].receipts
+}
+
+// reorganize replaces the canonical blocks after the last block shared
+// with the branch ending in newTip by the blocks of that branch. If that
+// fails, the chain is put back on the branch ending in oldTip.
+func (bc *Blockchain) reorganize(oldTip, newTip *blockNode) error {
+	fork := findFork(oldTip, newTip)
+
+	removed := []*blockNode{} // tip first
+	for n := oldTip; n != fork; n = n.parent {
+		removed = append(removed, n)
+	}
+	added := []*blockNode{} // oldest first
+	for n := newTip; n != fork; n = n.parent {
+		added = append([]*blockNode{n}, added...)
+	}
+
+	// read the bodies of the removed blocks before anything changes, so
+	// that a failure leaves the chain as it was
+	bodies := make([]*Block, len(removed))
+	for i, n := range removed {
+		b, err := bc.store.GetByHeight(n.header.Height)
+		if err != nil {
+			return fmt.Errorf("reorganizing chain at height %d: %w", fork.header.Height, err)
+		}
+		bodies[i] = b
+	}
+
+	// the store goes back first, so that nothing has changed if it can't.
+	// The in-memory chain is rebuilt from the store after a crash, so it
+	// being ahead until it follows doesn't matter.
+	if err := bc.store.Rewind(fork.header.Height); err != nil {
+		return fmt.Errorf("reorganizing chain at height %d: %w", fork.header.Height, err)
+	}
+	for i, n := range removed {
+		bc.unapplyBlock(n, bodies[i])
+	}
+
+	bc.logger.Log("msg", "reorganizing chain",
+		"fork", fork.header.Height,
+		"removed", len(removed),
+		"added", len(added),
+		"hash", newTip.hash,
+	)
+
+	addedBlocks := make([]*Block, len(added))
+	receipts := make([][]*Receipt, len(added))
+	for i, n := range added {
+		addedBlocks[i] = n.block
+		if err := bc.addBlockWithoutValidation(n.block); err != nil {
+			err = fmt.Errorf("reorganizing chain at height %d: %w", fork.header.Height, err)
+			if restoreErr := bc.restoreBranch(fork, added[:i], addedBlocks[:i], bodies); restoreErr != nil {
+				return fmt.Errorf("%v, and restoring the previous chain failed: %w", err, restoreErr)
+			}
+			return err
+		}
+		receipts[i] = bc.receiptsOf(n.hash)
+	}
+
+	bc.dropFinalSideBlocks()
+	bc.snapshotState()
+
+	events := []ChainEvent{
+		BlockAddedEvent{Block: addedBlocks[len(addedBlocks)-1], Canonical: true},
+		ReorgEvent{Removed: bodies, Added: addedBlocks},
+	}
+	bc.events.send(append(events, canonicalEvents(addedBlocks, receipts)...)...)
+	return nil
+}
+
+// restoreBranch undoes a reorganization that failed after the nodes of
+// added, with the bodies blocks, were applied on top of fork, and applies
+// the removed blocks, tip first, again.
+func (bc *Blockchain) restoreBranch(fork *blockNode, added []*blockNode, blocks []*Block, removed []*Block) error {
+	if err := bc.store.Rewind(fork.header.Height); err != nil {
+		return err
+	}
+	for i := len(added) - 1; i >= 0; i-- {
+		bc.unapplyBlock(added[i], blocks[i])
+	}
+
+	for i := len(removed) - 1; i >= 0; i-- {
+		if err := bc.addBlockWithoutValidation(removed[i]); err != nil {
+			return err
+		}
+	}
+
+	bc.logger.Log("msg", "restored chain after failed reorganization",
+		"height", bc.Height(),
+	)
+	return nil
+}
+
+// unapplyBlock takes n, the tip of the canonical chain whose body is b,
+// off the chain and keeps it as a side block.
+func (bc *Blockchain) unapplyBlock(n *blockNode, b *Block) {
+	bc.state.revert(n.undo)
+	bc.txIndex.Remove(b)
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	n.block = b
+	n.receipts = nil
+	n.undo = nil
+	bc.side[n.hash] = n
+	bc.chain = bc.chain[:len(bc.chain)-1]
+}
+
+// forkOf returns the last canonical block of the branch ending in n.
+func (bc *Blockchain) forkOf(n *blockNode) *blockNode {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	for n.block != nil {
+		n = n.parent
+	}
+	return n
+}
+
+// dropFinalSideBlocks forgets the side blocks whose branch forks deeper
+// than the finality depth, as the chain can't move onto them any more.
+func (bc *Blockchain) dropFinalSideBlocks() {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	tip := uint32(len(bc.chain) - 1)
+	for hash, n := range bc.side {
+		fork := n
+		for fork.block != nil {
+			fork = fork.parent
+		}
+		if tip-fork.header.Height > bc.finalityDepth {
+			delete(bc.side, hash)
+			delete(bc.nodes, hash)
+		}
+	}
+}
+
+// findFork returns the last block two branches have in common.
+func findFork(a, b *blockNode) *blockNode {
+	for a.header.Height > b.header.Height {
+		a = a.parent
+	}
+	for b.header.Height > a.header.Height {
+		b = b.parent
+	}
+	for a != b {
+		a, b = a.parent, b.parent
+	}
+	return a
+}
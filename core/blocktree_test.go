@@ -0,0 +1,217 @@
+package core
+
+import (
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// storeTx returns a transaction whose program stores 42 under key of its
+// sender's contract.
+func storeTx(t *testing.T, key byte) *Transaction {
+	return signedTransaction(t, program(push1(42), push1(key), InstrSStore))
+}
+
+func hasStored(bc *Blockchain, tx *Transaction, key byte) bool {
+	_, ok := bc.State().Get(tx.From.Address(), wordBytes(big.NewInt(int64(key))))
+	return ok
+}
+
+func assertCanonical(t *testing.T, bc *Blockchain, b *Block) {
+	header, err := bc.GetHeader(b.Height)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(BlockHasher{}), BlockHasher{}.Hash(header))
+}
+
+func TestBlockchainReorganize(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesis := getPrevBlockHash(t, bc, 1)
+
+	txA, txB := storeTx(t, 1), storeTx(t, 2)
+
+	a1 := signedBlock(t, 1, genesis, txA)
+	a2 := randomBlock(t, 2, a1.Hash(BlockHasher{}))
+	assert.Nil(t, bc.AddBlock(a1))
+	assert.Nil(t, bc.AddBlock(a2))
+
+	// a branch as long as the chain doesn't replace it
+	b1 := signedBlock(t, 1, genesis, txB)
+	b2 := randomBlock(t, 2, b1.Hash(BlockHasher{}))
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, uint32(2), bc.Height())
+	assertCanonical(t, bc, a1)
+	assert.False(t, hasStored(bc, txB, 2))
+
+	// a longer one does
+	b3 := randomBlock(t, 3, b2.Hash(BlockHasher{}))
+	assert.Nil(t, bc.AddBlock(b3))
+	assert.Equal(t, uint32(3), bc.Height())
+	assertCanonical(t, bc, b1)
+	assertCanonical(t, bc, b3)
+
+	assert.False(t, hasStored(bc, txA, 1))
+	assert.True(t, hasStored(bc, txB, 2))
+	_, _, err := bc.GetTransaction(txA.Hash(TxHasher{}))
+	assert.NotNil(t, err)
+	_, err = bc.GetReceipt(txA.Hash(TxHasher{}))
+	assert.NotNil(t, err)
+	_, loc, err := bc.GetTransaction(txB.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, TxLocation{Height: 1, Index: 0}, *loc)
+
+	// blocks taken off the chain can still be read
+	b, err := bc.GetBlockByHash(a1.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Len(t, b.Transactions, 1)
+
+	// and the old branch can win again
+	a3 := randomBlock(t, 3, a2.Hash(BlockHasher{}))
+	a4 := randomBlock(t, 4, a3.Hash(BlockHasher{}))
+	assert.Nil(t, bc.AddBlock(a3))
+	assert.Nil(t, bc.AddBlock(a4))
+	assert.Equal(t, uint32(4), bc.Height())
+	assertCanonical(t, bc, a1)
+	assert.True(t, hasStored(bc, txA, 1))
+	assert.False(t, hasStored(bc, txB, 2))
+	_, err = bc.GetReceipt(txA.Hash(TxHasher{}))
+	assert.Nil(t, err)
+}
+
+func TestBlockchainRejectsUnknownParent(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	err := bc.AddBlock(randomBlock(t, 1, types.RandomHash()))
+	assert.ErrorIs(t, err, ErrUnknownParent)
+
+	// a block that is invalid on its own is rejected as such
+	unsigned, err := NewBlock(&Header{Version: 1, Height: 1, PrevBlockHash: types.RandomHash()}, nil)
+	assert.Nil(t, err)
+	err = bc.AddBlock(unsigned)
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, ErrUnknownParent)
+}
+
+func TestBlockchainReorganizeStorage(t *testing.T) {
+	dir := t.TempDir()
+	logger := log.NewLogfmtLogger(os.Stderr)
+	genesis := randomBlock(t, 0, types.Hash{})
+
+	store := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	bc, err := NewBlockchainWithStorage(logger, store, genesis)
+	assert.Nil(t, err)
+
+	a1 := randomBlock(t, 1, genesis.Hash(BlockHasher{}))
+	b1 := randomBlock(t, 1, genesis.Hash(BlockHasher{}))
+	b2 := randomBlock(t, 2, b1.Hash(BlockHasher{}))
+	for _, b := range []*Block{a1, b1, b2} {
+		assert.Nil(t, bc.AddBlock(b))
+	}
+	assert.Nil(t, store.Close())
+
+	store = newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	defer store.Close()
+	bc, err = NewBlockchainWithStorage(logger, store, genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), bc.Height())
+	assertCanonical(t, bc, b1)
+	assertCanonical(t, bc, b2)
+}
+
+// reorgFixture builds the chain genesis-a1-a2 with txA in a1 and the side
+// branch genesis-b1-b2 with txB in b1, and returns b3, which makes the side
+// branch the longer one.
+func reorgFixture(t *testing.T, store Storage) (*Blockchain, []*Block, *Block, *Transaction, *Transaction) {
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+	genesis := getPrevBlockHash(t, bc, 1)
+
+	txA, txB := storeTx(t, 1), storeTx(t, 2)
+	a1 := signedBlock(t, 1, genesis, txA)
+	a2 := randomBlock(t, 2, a1.Hash(BlockHasher{}))
+	b1 := signedBlock(t, 1, genesis, txB)
+	b2 := randomBlock(t, 2, b1.Hash(BlockHasher{}))
+	for _, b := range []*Block{a1, a2, b1, b2} {
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	return bc, []*Block{a1, a2, b1, b2}, randomBlock(t, 3, b2.Hash(BlockHasher{})), txA, txB
+}
+
+func assertOnFirstBranch(t *testing.T, bc *Blockchain, store Storage, blocks []*Block, txA, txB *Transaction) {
+	assert.Equal(t, uint32(2), bc.Height())
+	assertCanonical(t, bc, blocks[0])
+	assertCanonical(t, bc, blocks[1])
+	stored, err := store.GetByHeight(2)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[1].Hash(BlockHasher{}), stored.Hash(BlockHasher{}))
+
+	assert.True(t, hasStored(bc, txA, 1))
+	assert.False(t, hasStored(bc, txB, 2))
+	_, _, err = bc.GetTransaction(txA.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	_, err = bc.GetReceipt(txA.Hash(TxHasher{}))
+	assert.Nil(t, err)
+}
+
+func TestBlockchainReorganizeRestoresOnFailure(t *testing.T) {
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	bc, blocks, b3, txA, txB := reorgFixture(t, store)
+
+	// the second block of the new branch can't be stored
+	store.failBlock = blocks[3].Hash(BlockHasher{})
+	assert.NotNil(t, bc.AddBlock(b3))
+	assertOnFirstBranch(t, bc, store, blocks, txA, txB)
+
+	// and once it can, the reorganization goes through
+	store.failBlock = types.Hash{}
+	b4 := randomBlock(t, 4, b3.Hash(BlockHasher{}))
+	assert.Nil(t, bc.AddBlock(b4))
+	assert.Equal(t, uint32(4), bc.Height())
+	assertCanonical(t, bc, blocks[3])
+	assert.True(t, hasStored(bc, txB, 2))
+	assert.False(t, hasStored(bc, txA, 1))
+}
+
+func TestBlockchainReorganizeRewindFailure(t *testing.T) {
+	store := &failingStore{MemoryStore: NewMemoryStore()}
+	bc, blocks, b3, txA, txB := reorgFixture(t, store)
+
+	store.failRewind = true
+	assert.NotNil(t, bc.AddBlock(b3))
+	assertOnFirstBranch(t, bc, store, blocks, txA, txB)
+}
+
+func TestBlockchainFinalityDepth(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.finalityDepth = 2
+
+	chain := []*Block{}
+	for height := uint32(1); height <= 4; height++ {
+		b := signedBlock(t, height, getPrevBlockHash(t, bc, height), storeTx(t, byte(height)))
+		assert.Nil(t, bc.AddBlock(b))
+		chain = append(chain, b)
+	}
+
+	// a branch that would take more than two blocks off the chain is
+	// refused
+	assert.NotNil(t, bc.AddBlock(randomBlock(t, 2, chain[0].Hash(BlockHasher{}))))
+
+	side := randomBlock(t, 3, chain[1].Hash(BlockHasher{}))
+	assert.Nil(t, bc.AddBlock(side))
+	assert.True(t, bc.HasBlockHash(side.Hash(BlockHasher{})))
+
+	// and side blocks are dropped once they get that deep
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 5, chain[3].Hash(BlockHasher{}))))
+	assert.False(t, bc.HasBlockHash(side.Hash(BlockHasher{})))
+	assert.Len(t, bc.side, 0)
+	assert.Len(t, bc.nodes, 6)
+
+	// blocks that are final keep nothing to undo them
+	assert.Nil(t, bc.chain[3].undo)
+	assert.NotNil(t, bc.chain[4].undo)
+}
@@ -0,0 +1,333 @@
+package core
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// lru is a bounded map from height to value that evicts the least
+// recently used entry.
+type lru[V any] struct {
+	size    int
+	order   *list.List // of *lruEntry[V], most recently used first
+	entries map[uint32]*list.Element
+}
+
+type lruEntry[V any] struct {
+	height uint32
+	value  V
+}
+
+func newLRU[V any](size int) *lru[V] {
+	return &lru[V]{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[uint32]*list.Element),
+	}
+}
+
+func (c *lru[V]) get(height uint32) (V, bool) {
+	e, ok := c.entries[height]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[V]).value, true
+}
+
+// add stores value and returns the evicted entry, if any.
+func (c *lru[V]) add(height uint32, value V) (*lruEntry[V], bool) {
+	if e, ok := c.entries[height]; ok {
+		e.Value.(*lruEntry[V]).value = value
+		c.order.MoveToFront(e)
+		return nil, false
+	}
+
+	c.entries[height] = c.order.PushFront(&lruEntry[V]{height: height, value: value})
+	if c.order.Len() <= c.size {
+		return nil, false
+	}
+
+	oldest := c.order.Back()
+	c.order.Remove(oldest)
+	evicted := oldest.Value.(*lruEntry[V])
+	delete(c.entries, evicted.height)
+	return evicted, true
+}
+
+func (c *lru[V]) remove(height uint32) {
+	if e, ok := c.entries[height]; ok {
+		c.order.Remove(e)
+		delete(c.entries, height)
+	}
+}
+
+// CacheStats counts the reads a CachedStore answered from memory and the
+// ones it passed on to the store beneath.
+type CacheStats struct {
+	BlockHits    uint64
+	BlockMisses  uint64
+	HeaderHits   uint64
+	HeaderMisses uint64
+}
+
+// CachedStore is a Storage that keeps the most recently read and written
+// blocks and headers of another Storage in memory. Errors, such as the
+// ones for pruned blocks, are never cached, and cached blocks the store
+// has pruned since are dropped instead of returned.
+type CachedStore struct {
+	store Storage
+
+	lock    sync.Mutex
+	blocks  *lru[*Block]
+	hashes  map[types.Hash]uint32 // heights of the cached blocks
+	headers *lru[*Header]
+	stats   CacheStats
+}
+
+// prunedStorage is a Storage that cuts old blocks down to their header.
+type prunedStorage interface {
+	Pruned() uint32
+}
+
+// NewCachedStore returns a CachedStore in front of s that holds up to
+// size blocks and size headers.
+func NewCachedStore(s Storage, size int) *CachedStore {
+	if size < 1 {
+		size = 1
+	}
+	return &CachedStore{
+		store:   s,
+		blocks:  newLRU[*Block](size),
+		hashes:  make(map[types.Hash]uint32),
+		headers: newLRU[*Header](size),
+	}
+}
+
+func (c *CachedStore) Put(b *Block) error {
+	if err := c.store.Put(b); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// the new tip is what gets read next
+	c.addBlock(b)
+	return nil
+}
+
+func (c *CachedStore) Has(hash types.Hash) bool {
+	c.lock.Lock()
+	_, ok := c.hashes[hash]
+	c.lock.Unlock()
+
+	return ok || c.store.Has(hash)
+}
+
+func (c *CachedStore) GetByHash(hash types.Hash) (*Block, error) {
+	c.lock.Lock()
+	if height, ok := c.hashes[hash]; ok && !c.dropPruned(height) {
+		b, _ := c.blocks.get(height)
+		c.stats.BlockHits++
+		c.lock.Unlock()
+		return b, nil
+	}
+	c.stats.BlockMisses++
+	c.lock.Unlock()
+
+	b, err := c.store.GetByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.addBlock(b)
+	c.lock.Unlock()
+
+	return b, nil
+}
+
+func (c *CachedStore) GetByHeight(height uint32) (*Block, error) {
+	c.lock.Lock()
+	if b, ok := c.blocks.get(height); ok && !c.dropPruned(height) {
+		c.stats.BlockHits++
+		c.lock.Unlock()
+		return b, nil
+	}
+	c.stats.BlockMisses++
+	c.lock.Unlock()
+
+	b, err := c.store.GetByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.addBlock(b)
+	c.lock.Unlock()
+
+	return b, nil
+}
+
+func (c *CachedStore) GetHeader(height uint32) (*Header, error) {
+	c.lock.Lock()
+	if h, ok := c.headers.get(height); ok {
+		c.stats.HeaderHits++
+		c.lock.Unlock()
+		return h, nil
+	}
+	c.stats.HeaderMisses++
+	c.lock.Unlock()
+
+	h, err := c.store.GetHeader(height)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	c.headers.add(height, h)
+	c.lock.Unlock()
+
+	return h, nil
+}
+
+func (c *CachedStore) Iterate(from, to uint32, fn func(*Block) error) error {
+	return iterateByHeight(c.GetByHeight, from, to, fn)
+}
+
+func (c *CachedStore) Rewind(height uint32) error {
+	if err := c.store.Rewind(height); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for h, e := range c.blocks.entries {
+		if h > height {
+			delete(c.hashes, e.Value.(*lruEntry[*Block]).value.Hash(BlockHasher{}))
+			c.blocks.remove(h)
+		}
+	}
+	for h := range c.headers.entries {
+		if h > height {
+			c.headers.remove(h)
+		}
+	}
+	return nil
+}
+
+// PutSnapshot passes the state snapshot on to the store beneath, which
+// drops it if it keeps none.
+func (c *CachedStore) PutSnapshot(snapshot *StateSnapshot) error {
+	ss, ok := c.store.(SnapshotStorage)
+	if !ok {
+		return nil
+	}
+	return ss.PutSnapshot(snapshot)
+}
+
+func (c *CachedStore) GetSnapshot() (*StateSnapshot, error) {
+	ss, ok := c.store.(SnapshotStorage)
+	if !ok {
+		return nil, ErrSnapshotNotFound
+	}
+	return ss.GetSnapshot()
+}
+
+// PutGenesis passes the genesis on to the store beneath, which drops it
+// if it keeps none.
+func (c *CachedStore) PutGenesis(g *Genesis) error {
+	gs, ok := c.store.(GenesisStorage)
+	if !ok {
+		return nil
+	}
+	return gs.PutGenesis(g)
+}
+
+func (c *CachedStore) GetGenesis() (*Genesis, error) {
+	gs, ok := c.store.(GenesisStorage)
+	if !ok {
+		return nil, ErrGenesisNotFound
+	}
+	return gs.GetGenesis()
+}
+
+func (c *CachedStore) keepsState() bool {
+	_, ok := keptState(c.store)
+	return ok
+}
+
+func (c *CachedStore) putBlock(b *Block, receipts []*Receipt, writes map[types.Address]map[string][]byte, undo []stateChange) error {
+	ss, ok := keptState(c.store)
+	if !ok {
+		return fmt.Errorf("store beneath the cache doesn't keep the state")
+	}
+	if err := ss.putBlock(b, receipts, writes, undo); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.addBlock(b)
+	return nil
+}
+
+func (c *CachedStore) state() (map[types.Address]map[string][]byte, error) {
+	ss, ok := keptState(c.store)
+	if !ok {
+		return nil, fmt.Errorf("store beneath the cache doesn't keep the state")
+	}
+	return ss.state()
+}
+
+func (c *CachedStore) blockState(height uint32) ([]*Receipt, []stateChange, error) {
+	ss, ok := keptState(c.store)
+	if !ok {
+		return nil, nil, fmt.Errorf("store beneath the cache doesn't keep the state")
+	}
+	return ss.blockState(height)
+}
+
+// Stats returns the hit and miss counters of the cache.
+func (c *CachedStore) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// dropPruned removes the cached block with height if the store beneath
+// has pruned it, and reports whether it did. The caller must hold the
+// lock.
+func (c *CachedStore) dropPruned(height uint32) bool {
+	p, ok := c.store.(prunedStorage)
+	if !ok || height >= p.Pruned() {
+		return false
+	}
+
+	if b, ok := c.blocks.get(height); ok {
+		delete(c.hashes, b.Hash(BlockHasher{}))
+		c.blocks.remove(height)
+	}
+	return true
+}
+
+// addBlock caches b together with its header. The caller must hold the
+// lock.
+func (c *CachedStore) addBlock(b *Block) {
+	hash := b.Hash(BlockHasher{})
+	if old, ok := c.blocks.get(b.Height); ok {
+		delete(c.hashes, old.Hash(BlockHasher{}))
+	}
+	if evicted, ok := c.blocks.add(b.Height, b); ok {
+		delete(c.hashes, evicted.value.Hash(BlockHasher{}))
+	}
+	c.hashes[hash] = b.Height
+	c.headers.add(b.Height, b.Header)
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	c := newLRU[string](2)
+
+	_, evicted := c.add(1, "a")
+	assert.False(t, evicted)
+	c.add(2, "b")
+
+	// reading 1 makes 2 the least recently used
+	v, ok := c.get(1)
+	assert.True(t, ok)
+	assert.Equal(t, "a", v)
+
+	e, evicted := c.add(3, "c")
+	assert.True(t, evicted)
+	assert.Equal(t, uint32(2), e.height)
+
+	_, ok = c.get(2)
+	assert.False(t, ok)
+	_, ok = c.get(3)
+	assert.True(t, ok)
+}
+
+func TestCachedStore(t *testing.T) {
+	store := NewMemoryStore()
+	blocks := randomChain(t, 4)
+	for _, b := range blocks[:3] {
+		assert.Nil(t, store.Put(b))
+	}
+
+	c := NewCachedStore(store, 2)
+
+	b, err := c.GetByHeight(0)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[0], b)
+	_, err = c.GetByHeight(0)
+	assert.Nil(t, err)
+	assert.Equal(t, CacheStats{BlockHits: 1, BlockMisses: 1}, c.Stats())
+
+	// the header came along with the block
+	h, err := c.GetHeader(0)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[0].Header, h)
+	assert.Equal(t, uint64(1), c.Stats().HeaderHits)
+
+	// a stored block is cached right away and pushes out block 0
+	assert.Nil(t, c.Put(blocks[3]))
+	_, err = c.GetByHash(blocks[3].Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), c.Stats().BlockHits)
+
+	_, err = c.GetByHeight(1)
+	assert.Nil(t, err)
+	_, err = c.GetByHash(blocks[0].Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), c.Stats().BlockMisses)
+
+	assert.True(t, c.Has(blocks[2].Hash(BlockHasher{})))
+	assert.False(t, c.Has(types.RandomHash()))
+}
+
+func TestCachedStoreDoesNotCacheErrors(t *testing.T) {
+	dir := t.TempDir()
+	store := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1, PruneDepth: 1})
+	defer store.Close()
+	blocks := randomChain(t, 3)
+	for _, b := range blocks {
+		assert.Nil(t, store.Put(b))
+	}
+	putSnapshot(t, store, blocks, 2)
+
+	c := NewCachedStore(store, 4)
+	for i := 0; i < 2; i++ {
+		_, err := c.GetByHeight(0)
+		assert.ErrorIs(t, err, ErrBlockPruned)
+		_, err = c.GetByHeight(5)
+		assert.ErrorIs(t, err, ErrBlockNotFound)
+	}
+	assert.Equal(t, CacheStats{BlockMisses: 4}, c.Stats())
+
+	// headers of pruned blocks are still cached
+	_, err := c.GetHeader(0)
+	assert.Nil(t, err)
+	_, err = c.GetHeader(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), c.Stats().HeaderHits)
+}
+
+func TestCachedStoreDropsPrunedBlocks(t *testing.T) {
+	dir := t.TempDir()
+	store := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1, PruneDepth: 1})
+	defer store.Close()
+
+	c := NewCachedStore(store, 4)
+	blocks := randomChain(t, 3)
+	for _, b := range blocks {
+		assert.Nil(t, c.Put(b))
+	}
+
+	// the store prunes blocks the cache still holds
+	putSnapshot(t, c, blocks, 2)
+	assert.Equal(t, uint32(2), store.Pruned())
+
+	_, err := c.GetByHeight(0)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	_, err = c.GetByHash(blocks[1].Hash(BlockHasher{}))
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	assert.Len(t, c.hashes, 1)
+
+	_, err = c.GetByHeight(2)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), c.Stats().BlockHits)
+
+	// headers of pruned blocks are still cached
+	_, err = c.GetHeader(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), c.Stats().HeaderHits)
+}
@@ -0,0 +1,91 @@
+package core
+
+import "fmt"
+
+// Rules are the protocol rules blocks are produced and validated with.
+type Rules struct {
+	// Version is the header version blocks must carry.
+	Version uint32 `json:"version"`
+	// TxGasLimit is the gas each transaction's program may use.
+	TxGasLimit uint64 `json:"txGasLimit"`
+}
+
+// Upgrade puts Rules in force from Height on.
+type Upgrade struct {
+	Height uint32 `json:"height"`
+	Rules
+}
+
+// ChainConfig identifies a network and schedules its rule changes. Every
+// node of a network must have the same schedule, as nodes that apply
+// different rules at a height fork from each other there.
+type ChainConfig struct {
+	ChainID uint32 `json:"chainId"`
+	// Upgrades in ascending order of height, the first at genesis.
+	Upgrades []Upgrade `json:"upgrades"`
+}
+
+// DefaultChainConfig returns the rules the chain had before they could be
+// configured.
+func DefaultChainConfig() *ChainConfig {
+	return &ChainConfig{
+		Upgrades: []Upgrade{{
+			Height: 0,
+			Rules:  Rules{Version: 1, TxGasLimit: 1000000},
+		}},
+	}
+}
+
+// Rules returns the rules in force at height.
+func (c *ChainConfig) Rules(height uint32) Rules {
+	rules := c.Upgrades[0].Rules
+	for _, u := range c.Upgrades[1:] {
+		if u.Height > height {
+			break
+		}
+		rules = u.Rules
+	}
+	return rules
+}
+
+// extends reports whether c has the chain ID and every upgrade of old,
+// possibly followed by later ones.
+func (c *ChainConfig) extends(old *ChainConfig) bool {
+	if c.ChainID != old.ChainID || len(c.Upgrades) < len(old.Upgrades) {
+		return false
+	}
+	for i, u := range old.Upgrades {
+		if c.Upgrades[i] != u {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks that the schedule starts at genesis, that its heights
+// ascend and that versions never go back.
+func (c *ChainConfig) Validate() error {
+	if len(c.Upgrades) == 0 || c.Upgrades[0].Height != 0 {
+		return fmt.Errorf("chain config needs rules from height 0")
+	}
+
+	for i, u := range c.Upgrades {
+		if u.Version == 0 {
+			return fmt.Errorf("upgrade at height %d has no version", u.Height)
+		}
+		if u.TxGasLimit == 0 {
+			return fmt.Errorf("upgrade at height %d has no transaction gas limit", u.Height)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := c.Upgrades[i-1]
+		if u.Height <= prev.Height {
+			return fmt.Errorf("upgrade at height %d follows upgrade at height %d", u.Height, prev.Height)
+		}
+		if u.Version < prev.Version {
+			return fmt.Errorf("upgrade at height %d lowers version %d to %d", u.Height, prev.Version, u.Version)
+		}
+	}
+	return nil
+}
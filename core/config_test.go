@@ -0,0 +1,78 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func upgradedConfig() *ChainConfig {
+	return &ChainConfig{
+		ChainID: 7,
+		Upgrades: []Upgrade{
+			{Height: 0, Rules: Rules{Version: 1, TxGasLimit: 1000000}},
+			{Height: 2, Rules: Rules{Version: 2, TxGasLimit: 1}},
+		},
+	}
+}
+
+func TestChainConfigRules(t *testing.T) {
+	c := upgradedConfig()
+	assert.Nil(t, c.Validate())
+
+	assert.Equal(t, Rules{Version: 1, TxGasLimit: 1000000}, c.Rules(0))
+	assert.Equal(t, Rules{Version: 1, TxGasLimit: 1000000}, c.Rules(1))
+	assert.Equal(t, Rules{Version: 2, TxGasLimit: 1}, c.Rules(2))
+	assert.Equal(t, Rules{Version: 2, TxGasLimit: 1}, c.Rules(100))
+
+	assert.Nil(t, DefaultChainConfig().Validate())
+}
+
+func TestChainConfigValidate(t *testing.T) {
+	rules := Rules{Version: 1, TxGasLimit: 1}
+
+	invalid := []*ChainConfig{
+		{},
+		{Upgrades: []Upgrade{{Height: 1, Rules: rules}}},
+		{Upgrades: []Upgrade{{Height: 0, Rules: Rules{TxGasLimit: 1}}}},
+		{Upgrades: []Upgrade{{Height: 0, Rules: Rules{Version: 1}}}},
+		{Upgrades: []Upgrade{{Height: 0, Rules: rules}, {Height: 0, Rules: rules}}},
+		{Upgrades: []Upgrade{
+			{Height: 0, Rules: Rules{Version: 2, TxGasLimit: 1}},
+			{Height: 5, Rules: rules},
+		}},
+	}
+	for _, c := range invalid {
+		assert.NotNil(t, c.Validate())
+	}
+}
+
+func TestBlockchainUpgrade(t *testing.T) {
+	g := &Genesis{Config: upgradedConfig()}
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), NewMemoryStore(), g)
+	assert.Nil(t, err)
+	assert.NotEqual(t, (&Genesis{}).Hash(), bc.GenesisHash())
+
+	tx1 := storeTx(t, 1)
+	b1 := signedBlock(t, 1, bc.GenesisHash(), tx1)
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.True(t, hasStored(bc, tx1, 1))
+
+	// from height 2 on blocks need version 2
+	tx2 := storeTx(t, 2)
+	assert.NotNil(t, bc.AddBlock(signedBlock(t, 2, b1.Hash(BlockHasher{}), tx2)))
+
+	b2, err := NewBlockFromPrevHeader(bc.Config(), b1.Header, []*Transaction{tx2})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), b2.Version)
+	assert.Nil(t, b2.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, bc.AddBlock(b2))
+
+	// and their transactions run with the lower gas limit
+	receipt, err := bc.GetReceipt(tx2.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.True(t, receipt.Failed())
+	assert.False(t, hasStored(bc, tx2, 2))
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// ChainEvent is one of the events a Subscription delivers: HeadEvent,
+// BlockAddedEvent, ReorgEvent or TxIncludedEvent.
+type ChainEvent interface {
+	chainEvent()
+}
+
+// HeadEvent is sent when the tip of the canonical chain changes.
+type HeadEvent struct {
+	Header *Header
+	Hash   types.Hash
+}
+
+// BlockAddedEvent is sent for every block added to the block tree.
+// Canonical is false for a block that only extends a side branch.
+type BlockAddedEvent struct {
+	Block     *Block
+	Canonical bool
+}
+
+// ReorgEvent is sent when the canonical chain moves onto another branch.
+// Removed holds the blocks taken off the chain, tip first, and Added the
+// blocks that replaced them, oldest first.
+type ReorgEvent struct {
+	Removed []*Block
+	Added   []*Block
+}
+
+// TxIncludedEvent is sent for each transaction of a block that joins the
+// canonical chain.
+type TxIncludedEvent struct {
+	Tx       *Transaction
+	Location TxLocation
+	Receipt  *Receipt
+}
+
+func (HeadEvent) chainEvent()       {}
+func (BlockAddedEvent) chainEvent() {}
+func (ReorgEvent) chainEvent()      {}
+func (TxIncludedEvent) chainEvent() {}
+
+// Subscription receives chain events on a buffered channel. Delivery never
+// blocks the chain: events that don't fit in the buffer are dropped and
+// counted.
+type Subscription struct {
+	dropped uint64 // first for 64-bit alignment of atomic access
+	ch      chan ChainEvent
+}
+
+// Events returns the channel events are delivered on. It is closed when
+// the subscription ends.
+func (s *Subscription) Events() <-chan ChainEvent {
+	return s.ch
+}
+
+// Dropped returns the number of events missed because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// eventFeed sends events to the current subscriptions.
+type eventFeed struct {
+	lock sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscribe returns a subscription to the events of the chain, buffering
+// up to size of them.
+func (bc *Blockchain) Subscribe(size int) *Subscription {
+	sub := &Subscription{ch: make(chan ChainEvent, size)}
+
+	bc.events.lock.Lock()
+	defer bc.events.lock.Unlock()
+
+	if bc.events.subs == nil {
+		bc.events.subs = make(map[*Subscription]struct{})
+	}
+	bc.events.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe ends sub and closes its channel.
+func (bc *Blockchain) Unsubscribe(sub *Subscription) {
+	bc.events.lock.Lock()
+	defer bc.events.lock.Unlock()
+
+	if _, ok := bc.events.subs[sub]; ok {
+		delete(bc.events.subs, sub)
+		close(sub.ch)
+	}
+}
+
+func (f *eventFeed) send(events ...ChainEvent) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for sub := range f.subs {
+		for _, ev := range events {
+			select {
+			case sub.ch <- ev:
+			default:
+				atomic.AddUint64(&sub.dropped, 1)
+			}
+		}
+	}
+}
+
+// canonicalEvents returns the events for blocks joining the canonical
+// chain, their receipts in the same order.
+func canonicalEvents(blocks []*Block, receipts [][]*Receipt) []ChainEvent {
+	events := []ChainEvent{}
+	for i, b := range blocks {
+		for j, tx := range b.Transactions {
+			events = append(events, TxIncludedEvent{
+				Tx:       tx,
+				Location: TxLocation{Height: b.Height, Index: j},
+				Receipt:  receipts[i][j],
+			})
+		}
+	}
+
+	tip := blocks[len(blocks)-1]
+	return append(events, HeadEvent{
+		Header: tip.Header,
+		Hash:   tip.Hash(BlockHasher{}),
+	})
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveEvents(sub *Subscription) []ChainEvent {
+	events := []ChainEvent{}
+	for {
+		select {
+		case ev := <-sub.Events():
+			events = append(events, ev)
+		default:
+			return events
+		}
+	}
+}
+
+func TestSubscribeBlockEvents(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	sub := bc.Subscribe(16)
+
+	tx := storeTx(t, 1)
+	b := signedBlock(t, 1, getPrevBlockHash(t, bc, 1), tx)
+	assert.Nil(t, bc.AddBlock(b))
+
+	receipt, err := bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, []ChainEvent{
+		BlockAddedEvent{Block: b, Canonical: true},
+		TxIncludedEvent{Tx: tx, Location: TxLocation{Height: 1, Index: 0}, Receipt: receipt},
+		HeadEvent{Header: b.Header, Hash: b.Hash(BlockHasher{})},
+	}, receiveEvents(sub))
+}
+
+func TestSubscribeReorgEvents(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesis := getPrevBlockHash(t, bc, 1)
+
+	a1 := signedBlock(t, 1, genesis)
+	assert.Nil(t, bc.AddBlock(a1))
+
+	sub := bc.Subscribe(16)
+
+	tx := storeTx(t, 1)
+	b1 := signedBlock(t, 1, genesis, tx)
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Equal(t, []ChainEvent{BlockAddedEvent{Block: b1}}, receiveEvents(sub))
+
+	b2 := signedBlock(t, 2, b1.Hash(BlockHasher{}))
+	assert.Nil(t, bc.AddBlock(b2))
+
+	events := receiveEvents(sub)
+	assert.Len(t, events, 4)
+	assert.Equal(t, BlockAddedEvent{Block: b2, Canonical: true}, events[0])
+
+	reorg := events[1].(ReorgEvent)
+	assert.Len(t, reorg.Removed, 1)
+	assert.Equal(t, a1.Hash(BlockHasher{}), reorg.Removed[0].Hash(BlockHasher{}))
+	assert.Equal(t, []*Block{b1, b2}, reorg.Added)
+
+	included := events[2].(TxIncludedEvent)
+	assert.Equal(t, tx, included.Tx)
+	assert.Equal(t, TxLocation{Height: 1, Index: 0}, included.Location)
+	assert.Equal(t, HeadEvent{Header: b2.Header, Hash: b2.Hash(BlockHasher{})}, events[3])
+}
+
+func TestSubscriptionDropsWhenFull(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	full := bc.Subscribe(1)
+	other := bc.Subscribe(16)
+
+	b := signedBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.Nil(t, bc.AddBlock(b))
+
+	// a slow subscriber neither blocks the chain nor the others
+	assert.Len(t, receiveEvents(full), 1)
+	assert.Equal(t, uint64(1), full.Dropped())
+	assert.Len(t, receiveEvents(other), 2)
+	assert.Equal(t, uint64(0), other.Dropped())
+
+	bc.Unsubscribe(full)
+	_, ok := <-full.Events()
+	assert.False(t, ok)
+	bc.Unsubscribe(full)
+
+	assert.Nil(t, bc.AddBlock(signedBlock(t, 2, b.Hash(BlockHasher{}))))
+	assert.Len(t, receiveEvents(other), 2)
+}
@@ -0,0 +1,1009 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// SyncPolicy decides when a FileStore fsyncs its files.
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs after every block, so a stored block survives a
+	// power loss.
+	SyncAlways SyncPolicy = iota
+	// SyncBatch fsyncs once every FileStoreOpts.SyncEvery blocks.
+	SyncBatch
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+const (
+	defaultSegmentSize = 64 << 20
+	defaultSyncEvery   = 100
+
+	segmentPattern    = "segment-%06d.dat"
+	indexFile         = "index.dat"
+	headersFile       = "headers.dat"
+	snapshotFile      = "state.dat"
+	storedGenesisFile = "genesis.json"
+
+	// headersSegment is the segment id of index entries that point into
+	// the headers file.
+	headersSegment = math.MaxUint32
+
+	indexEntrySize = 52 // height, hash, segment, offset and length
+)
+
+type FileStoreOpts struct {
+	// SegmentSize is the size in bytes after which a new segment file is
+	// started.
+	SegmentSize int64
+	Sync        SyncPolicy
+	SyncEvery   int
+	// PruneDepth is the number of most recent blocks whose transactions
+	// are kept. Older blocks are cut down to their header, a whole
+	// segment at a time, once the state snapshot covers them. Zero keeps
+	// every block.
+	PruneDepth uint32
+	// ReadOnly opens the store without creating, repairing or pruning
+	// anything. Records past a damaged one are left out and the damage is
+	// reported by Damage, and Put fails.
+	ReadOnly bool
+}
+
+type blockLocation struct {
+	hash    types.Hash
+	segment uint32
+	offset  int64
+	length  uint32 // payload length, without the record header
+}
+
+// end returns the offset right after the record.
+func (l blockLocation) end() int64 {
+	return l.offset + recordHeaderSize + int64(l.length)
+}
+
+// FileStore is a Storage that appends gob encoded blocks to segment
+// files. An index file maps every height to the hash of its block and
+// the location of its record. On open, records that made it to a segment
+// but not to the index are indexed again and a torn record left by a
+// crash is cut off.
+//
+// When pruning, the headers of the blocks of an old segment are copied
+// to the headers file, the index is switched over to them and the
+// segment is deleted. Only blocks up to the state snapshot, kept in its
+// own file, are pruned, so that their effects on the state aren't lost.
+type FileStore struct {
+	lock sync.RWMutex
+	dir  string
+	opts FileStoreOpts
+
+	index      *os.File
+	headers    *os.File
+	active     *os.File
+	activeID   uint32
+	activeSize int64
+	unsynced   int
+
+	locations []blockLocation // by height
+	hashes    map[types.Hash]uint32
+	pruned    uint32 // number of blocks, from genesis on, cut down to their header
+	snapshot  int64  // height of the state snapshot, -1 without one
+	damage    error  // found past the last indexed block by a read-only open
+
+	readersLock sync.Mutex
+	readers     map[uint32]*os.File // read handles by segment id
+}
+
+func NewFileStore(dir string, opts FileStoreOpts) (*FileStore, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.SyncEvery <= 0 {
+		opts.SyncEvery = defaultSyncEvery
+	}
+
+	if !opts.ReadOnly {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &FileStore{
+		dir:      dir,
+		opts:     opts,
+		hashes:   make(map[types.Hash]uint32),
+		readers:  make(map[uint32]*os.File),
+		snapshot: -1,
+	}
+
+	if err := s.open(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Put(b *Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.opts.ReadOnly {
+		return fmt.Errorf("block store in %s is read-only", s.dir)
+	}
+	if b.Height != uint32(len(s.locations)) {
+		return fmt.Errorf("block with %d height can't be stored after height %d", b.Height, len(s.locations)-1)
+	}
+
+	record, err := encodeRecord(b)
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(record))
+	if s.activeSize > 0 && s.activeSize+size > s.opts.SegmentSize {
+		if err := s.rollSegment(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.active.Write(record); err != nil {
+		return err
+	}
+
+	loc := blockLocation{
+		hash:    b.Hash(BlockHasher{}),
+		segment: s.activeID,
+		offset:  s.activeSize,
+		length:  uint32(size - recordHeaderSize),
+	}
+	s.activeSize += size
+
+	if _, err := s.index.Write(encodeIndexEntry(b.Height, loc)); err != nil {
+		return err
+	}
+
+	s.locations = append(s.locations, loc)
+	s.hashes[loc.hash] = b.Height
+
+	if err := s.maybeSync(); err != nil {
+		return err
+	}
+	return s.prune()
+}
+
+func (s *FileStore) Has(hash types.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.hashes[hash]
+	return ok
+}
+
+func (s *FileStore) GetByHash(hash types.Hash) (*Block, error) {
+	s.lock.RLock()
+	height, ok := s.hashes[hash]
+	s.lock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: hash %s", ErrBlockNotFound, hash)
+	}
+	return s.GetByHeight(height)
+}
+
+func (s *FileStore) GetByHeight(height uint32) (*Block, error) {
+	loc, err := s.location(height)
+	if err != nil {
+		return nil, err
+	}
+	if loc.segment == headersSegment {
+		return nil, fmt.Errorf("%w: height %d", ErrBlockPruned, height)
+	}
+	return s.read(height, loc)
+}
+
+func (s *FileStore) GetHeader(height uint32) (*Header, error) {
+	loc, err := s.location(height)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.read(height, loc)
+	if err != nil {
+		return nil, err
+	}
+	return b.Header, nil
+}
+
+// SignedHeader returns the block with height without its transactions,
+// which is all that is kept of a pruned block.
+func (s *FileStore) SignedHeader(height uint32) (*Block, error) {
+	loc, err := s.location(height)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.read(height, loc)
+	if err != nil {
+		return nil, err
+	}
+	return &Block{Header: b.Header, Validator: b.Validator, Signature: b.Signature}, nil
+}
+
+func (s *FileStore) Iterate(from, to uint32, fn func(*Block) error) error {
+	return iterateByHeight(s.GetByHeight, from, to, fn)
+}
+
+// Rewind removes the blocks above height. Blocks whose transactions were
+// pruned can't be removed.
+func (s *FileStore) Rewind(height uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.opts.ReadOnly {
+		return fmt.Errorf("block store in %s is read-only", s.dir)
+	}
+	if int(height)+1 >= len(s.locations) {
+		return nil
+	}
+	if s.pruned > height+1 {
+		return fmt.Errorf("%w: can't rewind to height %d", ErrBlockPruned, height)
+	}
+	if int64(height) < s.snapshot {
+		// the pruned blocks need the snapshot, which would no longer
+		// belong to a stored block
+		if s.pruned > 0 {
+			return fmt.Errorf("can't rewind to height %d below the state snapshot at height %d", height, s.snapshot)
+		}
+		if err := os.Remove(s.snapshotPath()); err != nil {
+			return err
+		}
+		s.snapshot = -1
+	}
+
+	// the segments go first: index entries pointing past them are dropped
+	// on open anyway
+	first := s.locations[height+1]
+	for id := s.activeID; id > first.segment; id-- {
+		s.closeReader(id)
+		if err := os.Remove(s.segmentPath(id)); err != nil {
+			return err
+		}
+	}
+	if first.segment != s.activeID {
+		s.active.Close()
+		f, err := s.openFile(s.segmentPath(first.segment))
+		if err != nil {
+			return err
+		}
+		s.active = f
+		s.activeID = first.segment
+	}
+	if err := s.active.Truncate(first.offset); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	s.activeSize = first.offset
+
+	if err := s.index.Truncate(int64(height+1) * indexEntrySize); err != nil {
+		return err
+	}
+	if err := s.index.Sync(); err != nil {
+		return err
+	}
+
+	for _, loc := range s.locations[height+1:] {
+		delete(s.hashes, loc.hash)
+	}
+	s.locations = s.locations[:height+1]
+	s.unsynced = 0
+	return nil
+}
+
+// PutSnapshot atomically replaces the state snapshot and prunes the
+// blocks it covers that are older than PruneDepth.
+func (s *FileStore) PutSnapshot(snapshot *StateSnapshot) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.opts.ReadOnly {
+		return fmt.Errorf("block store in %s is read-only", s.dir)
+	}
+	if int(snapshot.Height) >= len(s.locations) || s.locations[snapshot.Height].hash != snapshot.Hash {
+		return fmt.Errorf("state snapshot of block %s with %d height isn't of a stored block", snapshot.Hash, snapshot.Height)
+	}
+	if int64(snapshot.Height) < s.snapshot {
+		return fmt.Errorf("state snapshot at height %d is older than the one at height %d", snapshot.Height, s.snapshot)
+	}
+
+	record, err := encodeSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.dir, s.snapshotPath(), record); err != nil {
+		return err
+	}
+	s.snapshot = int64(snapshot.Height)
+
+	return s.prune()
+}
+
+// GetSnapshot returns the state snapshot, ErrSnapshotNotFound when there
+// is none or it doesn't belong to a stored block.
+func (s *FileStore) GetSnapshot() (*StateSnapshot, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.snapshot < 0 {
+		return nil, ErrSnapshotNotFound
+	}
+	return s.readSnapshot()
+}
+
+// PutGenesis atomically replaces the stored genesis by g.
+func (s *FileStore) PutGenesis(g *Genesis) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.opts.ReadOnly {
+		return fmt.Errorf("block store in %s is read-only", s.dir)
+	}
+
+	data, err := json.MarshalIndent(g, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.dir, filepath.Join(s.dir, storedGenesisFile), data)
+}
+
+func (s *FileStore) GetGenesis() (*Genesis, error) {
+	path := filepath.Join(s.dir, storedGenesisFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, ErrGenesisNotFound
+	}
+	return LoadGenesis(path)
+}
+
+// Damage returns what a read-only store found wrong past its last
+// readable block when it was opened, nil if nothing. A writable store
+// repairs such damage by cutting it off instead.
+func (s *FileStore) Damage() error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.damage
+}
+
+// Pruned returns the number of blocks, from genesis on, whose
+// transactions were pruned.
+func (s *FileStore) Pruned() uint32 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.pruned
+}
+
+func (s *FileStore) location(height uint32) (blockLocation, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if int(height) >= len(s.locations) {
+		return blockLocation{}, fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+	}
+	return s.locations[height], nil
+}
+
+func (s *FileStore) read(height uint32, loc blockLocation) (*Block, error) {
+	r, err := s.reader(loc.segment)
+	if err != nil {
+		return nil, err
+	}
+
+	b, _, err := readRecord(r, loc.offset, loc.end())
+	if err != nil {
+		return nil, fmt.Errorf("reading block with %d height: %w", height, err)
+	}
+	return b, nil
+}
+
+func (s *FileStore) reader(segment uint32) (*os.File, error) {
+	s.readersLock.Lock()
+	defer s.readersLock.Unlock()
+
+	if f, ok := s.readers[segment]; ok {
+		return f, nil
+	}
+
+	f, err := os.Open(s.segmentPath(segment))
+	if err != nil {
+		return nil, err
+	}
+	s.readers[segment] = f
+	return f, nil
+}
+
+func (s *FileStore) closeReader(segment uint32) {
+	s.readersLock.Lock()
+	defer s.readersLock.Unlock()
+
+	if f, ok := s.readers[segment]; ok {
+		f.Close()
+		delete(s.readers, segment)
+	}
+}
+
+// Close syncs and closes the files of the store.
+func (s *FileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.readersLock.Lock()
+	for id, f := range s.readers {
+		f.Close()
+		delete(s.readers, id)
+	}
+	s.readersLock.Unlock()
+
+	var firstErr error
+	for _, f := range []*os.File{s.active, s.headers, s.index} {
+		if f == nil {
+			continue
+		}
+		if s.opts.ReadOnly {
+			f.Close()
+			continue
+		}
+		if err := f.Sync(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.active = nil
+	s.headers = nil
+	s.index = nil
+
+	return firstErr
+}
+
+func (s *FileStore) maybeSync() error {
+	s.unsynced++
+
+	switch s.opts.Sync {
+	case SyncAlways:
+	case SyncBatch:
+		if s.unsynced < s.opts.SyncEvery {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	// segment first, so a synced index never points past synced data
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	if err := s.index.Sync(); err != nil {
+		return err
+	}
+	s.unsynced = 0
+	return nil
+}
+
+func (s *FileStore) rollSegment() error {
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.segmentPath(s.activeID+1), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.activeID++
+	s.activeSize = 0
+	return nil
+}
+
+func (s *FileStore) snapshotPath() string {
+	return filepath.Join(s.dir, snapshotFile)
+}
+
+func (s *FileStore) segmentPath(id uint32) string {
+	if id == headersSegment {
+		return filepath.Join(s.dir, headersFile)
+	}
+	return filepath.Join(s.dir, fmt.Sprintf(segmentPattern, id))
+}
+
+// prune cuts down every segment that only holds blocks older than
+// PruneDepth and covered by the state snapshot. The active segment is
+// never pruned.
+func (s *FileStore) prune() error {
+	if s.opts.ReadOnly || s.opts.PruneDepth == 0 || uint32(len(s.locations)) <= s.opts.PruneDepth || s.snapshot < 0 {
+		return nil
+	}
+	keep := uint32(len(s.locations)) - s.opts.PruneDepth
+	if covered := uint32(s.snapshot) + 1; covered < keep {
+		keep = covered
+	}
+
+	for s.pruned < keep {
+		segment := s.locations[s.pruned].segment
+		if segment == s.activeID {
+			return nil
+		}
+
+		end := s.pruned
+		for int(end) < len(s.locations) && s.locations[end].segment == segment {
+			end++
+		}
+		if end > keep {
+			return nil
+		}
+
+		if err := s.pruneSegment(segment, s.pruned, end); err != nil {
+			return fmt.Errorf("pruning segment %d: %w", segment, err)
+		}
+	}
+	return nil
+}
+
+// pruneSegment replaces the blocks with heights in [from, to), which make
+// up the whole of segment, by their headers. The headers are synced
+// before the index is swapped, so a crash leaves either the old or the
+// new index in place, both pointing at complete records.
+func (s *FileStore) pruneSegment(segment, from, to uint32) error {
+	info, err := s.headers.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+
+	locations := make([]blockLocation, len(s.locations))
+	copy(locations, s.locations)
+
+	records := []byte{}
+	for height := from; height < to; height++ {
+		b, err := s.read(height, s.locations[height])
+		if err != nil {
+			return err
+		}
+
+		record, err := encodeRecord(&Block{
+			Header:    b.Header,
+			Validator: b.Validator,
+			Signature: b.Signature,
+		})
+		if err != nil {
+			return err
+		}
+
+		locations[height] = blockLocation{
+			hash:    s.locations[height].hash,
+			segment: headersSegment,
+			offset:  offset + int64(len(records)),
+			length:  uint32(len(record) - recordHeaderSize),
+		}
+		records = append(records, record...)
+	}
+
+	if _, err := s.headers.Write(records); err != nil {
+		return err
+	}
+	if err := s.headers.Sync(); err != nil {
+		return err
+	}
+	// the new index also holds the entries of the active segment
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	if err := s.rewriteIndex(locations); err != nil {
+		return err
+	}
+
+	s.locations = locations
+	s.pruned = to
+
+	s.closeReader(segment)
+	return os.Remove(s.segmentPath(segment))
+}
+
+// rewriteIndex atomically replaces the index file by one holding
+// locations.
+func (s *FileStore) rewriteIndex(locations []blockLocation) error {
+	path := filepath.Join(s.dir, indexFile)
+
+	buf := make([]byte, 0, len(locations)*indexEntrySize)
+	for height, loc := range locations {
+		buf = append(buf, encodeIndexEntry(uint32(height), loc)...)
+	}
+	if err := writeFileAtomic(s.dir, path, buf); err != nil {
+		return err
+	}
+
+	s.index.Close()
+	var err error
+	s.index, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	return err
+}
+
+// writeFileAtomic replaces the file at path, in dir, by one holding
+// data. A crash leaves either the old or the new file in place.
+func writeFileAtomic(dir, path string, data []byte) error {
+	tmpPath := path + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// open loads the index and brings it in line with the segment files.
+func (s *FileStore) open() error {
+	var err error
+	if !s.opts.ReadOnly {
+		s.headers, err = os.OpenFile(s.segmentPath(headersSegment), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+
+	segments, err := s.segmentSizes()
+	if err != nil {
+		return err
+	}
+
+	s.index, err = s.openFile(filepath.Join(s.dir, indexFile))
+	if err != nil {
+		return err
+	}
+	entries, err := s.loadIndex(segments)
+	if err != nil {
+		return err
+	}
+	if err := s.recoverSegments(segments); err != nil {
+		return err
+	}
+	if s.opts.ReadOnly && s.damage == nil && entries > len(s.locations) {
+		s.damage = fmt.Errorf("index has entries up to height %d, past the last readable block", entries-1)
+	}
+	if segments, err = s.segmentSizes(); err != nil {
+		return err
+	}
+
+	lastID, err := s.removeStaleSegments(segments)
+	if err != nil {
+		return err
+	}
+	s.active, err = s.openFile(s.segmentPath(lastID))
+	if err != nil {
+		return err
+	}
+	info, err := s.active.Stat()
+	if err != nil {
+		return err
+	}
+	s.activeID = lastID
+	s.activeSize = info.Size()
+
+	for int(s.pruned) < len(s.locations) && s.locations[s.pruned].segment == headersSegment {
+		s.pruned++
+	}
+	if err := s.loadSnapshot(); err != nil {
+		return err
+	}
+
+	// pruning may have been turned on since the store was last open
+	return s.prune()
+}
+
+// loadSnapshot finds the height of the state snapshot. A snapshot that
+// doesn't belong to a stored block, because the blocks after a crash
+// were lost, is left alone but not used.
+func (s *FileStore) loadSnapshot() error {
+	snapshot, err := s.readSnapshot()
+	if errors.Is(err, ErrSnapshotNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if int(snapshot.Height) < len(s.locations) && s.locations[snapshot.Height].hash == snapshot.Hash {
+		s.snapshot = int64(snapshot.Height)
+	}
+	return nil
+}
+
+func (s *FileStore) readSnapshot() (*StateSnapshot, error) {
+	data, err := os.ReadFile(s.snapshotPath())
+	if os.IsNotExist(err) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		return nil, fmt.Errorf("state snapshot %s: %w", s.snapshotPath(), err)
+	}
+	return snapshot, nil
+}
+
+// openFile opens path for appending, or only for reading when the store
+// is read-only.
+func (s *FileStore) openFile(path string) (*os.File, error) {
+	if s.opts.ReadOnly {
+		return os.Open(path)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+}
+
+// segmentSizes returns the size of every segment file and of the headers
+// file, by segment id.
+func (s *FileStore) segmentSizes() (map[uint32]int64, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "segment-*.dat"))
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[uint32]int64, len(matches)+1)
+	for _, path := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "segment-"), ".dat")
+		id, err := strconv.ParseUint(name, 10, 32)
+		if err != nil || filepath.Base(path) != fmt.Sprintf(segmentPattern, id) {
+			return nil, fmt.Errorf("unexpected segment file %s", path)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		sizes[uint32(id)] = info.Size()
+	}
+
+	// a read-only store may predate the headers file
+	info, err := os.Stat(s.segmentPath(headersSegment))
+	if err == nil {
+		sizes[headersSegment] = info.Size()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return sizes, nil
+}
+
+// segmentIDs returns the ids of the segment files in ascending order,
+// without the headers file.
+func segmentIDs(segments map[uint32]int64) []uint32 {
+	ids := make([]uint32, 0, len(segments))
+	for id := range segments {
+		if id != headersSegment {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// loadIndex reads the index entries that point at complete records and
+// drops the rest. It returns the number of entries the index held,
+// counting a torn one.
+func (s *FileStore) loadIndex(segments map[uint32]int64) (int, error) {
+	data, err := io.ReadAll(s.index)
+	if err != nil {
+		return 0, err
+	}
+	entries := (len(data) + indexEntrySize - 1) / indexEntrySize
+
+	valid := 0
+	for ; valid+indexEntrySize <= len(data); valid += indexEntrySize {
+		height, loc := decodeIndexEntry(data[valid : valid+indexEntrySize])
+		size, ok := segments[loc.segment]
+		if height != uint32(len(s.locations)) || !ok || loc.end() > size {
+			break
+		}
+		s.locations = append(s.locations, loc)
+		s.hashes[loc.hash] = height
+	}
+
+	if valid != len(data) && !s.opts.ReadOnly {
+		return entries, s.index.Truncate(int64(valid))
+	}
+	return entries, nil
+}
+
+// recoverSegments indexes the records written after the last indexed one
+// and truncates a torn record at the end of the log. Records of blocks
+// that are indexed already, left behind by an interrupted prune, are
+// skipped.
+func (s *FileStore) recoverSegments(segments map[uint32]int64) error {
+	ids := segmentIDs(segments)
+
+	var (
+		segment uint32 = headersSegment
+		offset  int64
+	)
+	if n := len(s.locations); n > 0 {
+		last := s.locations[n-1]
+		segment = last.segment
+		offset = last.end()
+	}
+
+	// the headers file holds the oldest blocks, so it comes first
+	order := []uint32{}
+	if segment == headersSegment {
+		order = append(order, headersSegment)
+	}
+	for _, id := range ids {
+		if segment == headersSegment || id >= segment {
+			order = append(order, id)
+		}
+	}
+
+	for i, id := range order {
+		if i > 0 {
+			offset = 0
+		}
+		if err := s.recoverSegment(id, offset, segments[id], ids); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) recoverSegment(id uint32, offset, size int64, ids []uint32) error {
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for offset < size {
+		b, length, err := readRecord(f, offset, size)
+		if err == nil && b.Height < uint32(len(s.locations)) {
+			offset += recordHeaderSize + int64(length)
+			continue
+		}
+		if err == nil && b.Height != uint32(len(s.locations)) {
+			err = fmt.Errorf("unexpected height %d", b.Height)
+		}
+		if err != nil {
+			if s.opts.ReadOnly {
+				s.damage = fmt.Errorf("%s: %w", s.segmentPath(id), err)
+				return nil
+			}
+			if id == headersSegment {
+				// a prune was interrupted while writing headers
+				return os.Truncate(s.segmentPath(id), offset)
+			}
+			// everything from a bad record on is lost
+			return s.truncateLog(id, offset, ids)
+		}
+
+		loc := blockLocation{
+			hash:    b.Hash(BlockHasher{}),
+			segment: id,
+			offset:  offset,
+			length:  length,
+		}
+		if !s.opts.ReadOnly {
+			if _, err := s.index.Write(encodeIndexEntry(b.Height, loc)); err != nil {
+				return err
+			}
+		}
+		s.locations = append(s.locations, loc)
+		s.hashes[loc.hash] = b.Height
+
+		offset = loc.end()
+	}
+
+	return nil
+}
+
+func (s *FileStore) truncateLog(segment uint32, offset int64, ids []uint32) error {
+	if err := os.Truncate(s.segmentPath(segment), offset); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id <= segment {
+			continue
+		}
+		if err := os.Remove(s.segmentPath(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeStaleSegments deletes the segments no block points into anymore,
+// left behind by a prune interrupted after the index was swapped, and
+// returns the id of the last segment.
+func (s *FileStore) removeStaleSegments(segments map[uint32]int64) (uint32, error) {
+	ids := segmentIDs(segments)
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	lastID := ids[len(ids)-1]
+	if s.opts.ReadOnly {
+		return lastID, nil
+	}
+
+	used := make(map[uint32]bool)
+	for _, loc := range s.locations {
+		used[loc.segment] = true
+	}
+	for _, id := range ids {
+		if id == lastID || used[id] {
+			continue
+		}
+		if err := os.Remove(s.segmentPath(id)); err != nil {
+			return 0, err
+		}
+	}
+	return lastID, nil
+}
+
+func encodeIndexEntry(height uint32, loc blockLocation) []byte {
+	buf := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint32(buf[0:4], height)
+	copy(buf[4:36], loc.hash.ToSlice())
+	binary.BigEndian.PutUint32(buf[36:40], loc.segment)
+	binary.BigEndian.PutUint64(buf[40:48], uint64(loc.offset))
+	binary.BigEndian.PutUint32(buf[48:52], loc.length)
+	return buf
+}
+
+func decodeIndexEntry(buf []byte) (uint32, blockLocation) {
+	return binary.BigEndian.Uint32(buf[0:4]),
+		blockLocation{
+			hash:    types.HashFromBytes(buf[4:36]),
+			segment: binary.BigEndian.Uint32(buf[36:40]),
+			offset:  int64(binary.BigEndian.Uint64(buf[40:48])),
+			length:  binary.BigEndian.Uint32(buf[48:52]),
+		}
+}
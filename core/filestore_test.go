@@ -0,0 +1,286 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hitenjain14/go-blockchain/crypto"
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFileStore(t *testing.T, dir string, opts FileStoreOpts) *FileStore {
+	s, err := NewFileStore(dir, opts)
+	assert.Nil(t, err)
+	return s
+}
+
+// randomChain returns n linked blocks starting at height 0.
+func randomChain(t *testing.T, n int) []*Block {
+	blocks := []*Block{}
+	prevHash := types.Hash{}
+	for i := 0; i < n; i++ {
+		b := randomBlock(t, uint32(i), prevHash)
+		prevHash = b.Hash(BlockHasher{})
+		blocks = append(blocks, b)
+	}
+	return blocks
+}
+
+// putSnapshot stores an empty state snapshot of the block of blocks with
+// height.
+func putSnapshot(t *testing.T, s SnapshotStorage, blocks []*Block, height uint32) {
+	assert.Nil(t, s.PutSnapshot(&StateSnapshot{
+		Height: height,
+		Hash:   blocks[height].Hash(BlockHasher{}),
+	}))
+}
+
+func TestFileStorePut(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{})
+
+	blocks := randomChain(t, 3)
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+	assert.NotNil(t, s.Put(blocks[1]))
+	assert.Nil(t, s.Close())
+
+	s = newFileStore(t, dir, FileStoreOpts{})
+	defer s.Close()
+	assert.Len(t, s.locations, 3)
+	for i, b := range blocks {
+		assert.Equal(t, uint32(i), s.hashes[b.Hash(BlockHasher{})])
+	}
+	assert.NotNil(t, s.Put(blocks[2]))
+}
+
+func TestFileStoreSegments(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1, Sync: SyncNever})
+
+	for _, b := range randomChain(t, 3) {
+		assert.Nil(t, s.Put(b))
+	}
+	assert.Nil(t, s.Close())
+
+	matches, err := filepath.Glob(filepath.Join(dir, "segment-*.dat"))
+	assert.Nil(t, err)
+	assert.Len(t, matches, 3)
+
+	s = newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	defer s.Close()
+	assert.Len(t, s.locations, 3)
+	assert.Equal(t, uint32(2), s.activeID)
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{Sync: SyncBatch, SyncEvery: 2})
+
+	blocks := randomChain(t, 4)
+	for _, b := range blocks[:3] {
+		assert.Nil(t, s.Put(b))
+	}
+	assert.Nil(t, s.Close())
+
+	// lose the last index entry and tear a record at the end of the log
+	indexPath := filepath.Join(dir, indexFile)
+	assert.Nil(t, os.Truncate(indexPath, 2*indexEntrySize+10))
+
+	segment, err := os.OpenFile(filepath.Join(dir, "segment-000000.dat"), os.O_APPEND|os.O_WRONLY, 0o644)
+	assert.Nil(t, err)
+	_, err = segment.Write([]byte{0x00, 0x00, 0x01, 0x00, 0xde, 0xad})
+	assert.Nil(t, err)
+	assert.Nil(t, segment.Close())
+
+	s = newFileStore(t, dir, FileStoreOpts{})
+	assert.Len(t, s.locations, 3)
+	assert.Equal(t, uint32(2), s.hashes[blocks[2].Hash(BlockHasher{})])
+
+	// appending continues right after the last good record
+	assert.Nil(t, s.Put(blocks[3]))
+	assert.Nil(t, s.Close())
+
+	s = newFileStore(t, dir, FileStoreOpts{})
+	defer s.Close()
+	assert.Len(t, s.locations, 4)
+
+	info, err := os.Stat(indexPath)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4*indexEntrySize), info.Size())
+}
+
+func TestFileStorePrune(t *testing.T) {
+	dir := t.TempDir()
+	opts := FileStoreOpts{SegmentSize: 1, Sync: SyncNever, PruneDepth: 2}
+	s := newFileStore(t, dir, opts)
+
+	blocks := randomChain(t, 5)
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+	// nothing is pruned before a state snapshot covers it
+	assert.Equal(t, uint32(0), s.pruned)
+	putSnapshot(t, s, blocks, 4)
+
+	for height, b := range blocks {
+		header, err := s.GetHeader(uint32(height))
+		assert.Nil(t, err)
+		assert.Equal(t, b.Hash(BlockHasher{}), BlockHasher{}.Hash(header))
+		assert.True(t, s.Has(b.Hash(BlockHasher{})))
+
+		_, err = s.GetByHeight(uint32(height))
+		if height < 3 {
+			assert.ErrorIs(t, err, ErrBlockPruned)
+		} else {
+			assert.Nil(t, err)
+		}
+	}
+	_, err := s.GetByHash(blocks[0].Hash(BlockHasher{}))
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	assert.ErrorIs(t, s.Iterate(0, 4, func(*Block) error { return nil }), ErrBlockPruned)
+	assert.Nil(t, s.Close())
+
+	matches, err := filepath.Glob(filepath.Join(dir, "segment-*.dat"))
+	assert.Nil(t, err)
+	assert.Len(t, matches, 2)
+
+	s = newFileStore(t, dir, opts)
+	defer s.Close()
+	assert.Len(t, s.locations, 5)
+	assert.Equal(t, uint32(3), s.pruned)
+
+	b, err := s.GetByHeight(4)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[4].Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+
+	header, err := s.GetHeader(1)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[1].Hash(BlockHasher{}), BlockHasher{}.Hash(header))
+}
+
+func TestFileStorePruneOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	blocks := randomChain(t, 4)
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+	putSnapshot(t, s, blocks, 3)
+	assert.Nil(t, s.Close())
+
+	s = newFileStore(t, dir, FileStoreOpts{SegmentSize: 1, PruneDepth: 1})
+	defer s.Close()
+	assert.Equal(t, uint32(3), s.pruned)
+
+	_, err := s.GetByHeight(2)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	_, err = s.GetByHeight(3)
+	assert.Nil(t, err)
+}
+
+func TestFileStorePruneRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	blocks := randomChain(t, 3)
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+	putSnapshot(t, s, blocks, 2)
+	segment0, err := os.ReadFile(filepath.Join(dir, "segment-000000.dat"))
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	s = newFileStore(t, dir, FileStoreOpts{SegmentSize: 1, PruneDepth: 2})
+	assert.Equal(t, uint32(1), s.pruned)
+	assert.Nil(t, s.Close())
+
+	// a crash after the index was swapped leaves the pruned segment behind
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "segment-000000.dat"), segment0, 0o644))
+
+	s = newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	assert.Len(t, s.locations, 3)
+	_, err = s.GetByHeight(0)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	assert.Nil(t, s.Close())
+
+	_, err = os.Stat(filepath.Join(dir, "segment-000000.dat"))
+	assert.True(t, os.IsNotExist(err))
+
+	// a lost index is rebuilt from the headers file and the segments
+	assert.Nil(t, os.Remove(filepath.Join(dir, indexFile)))
+
+	s = newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	defer s.Close()
+	assert.Len(t, s.locations, 3)
+	for height, b := range blocks {
+		assert.Equal(t, uint32(height), s.hashes[b.Hash(BlockHasher{})])
+	}
+	_, err = s.GetByHeight(0)
+	assert.ErrorIs(t, err, ErrBlockPruned)
+	_, err = s.GetByHeight(2)
+	assert.Nil(t, err)
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	opts := FileStoreOpts{SegmentSize: 1, PruneDepth: 1}
+	s := newFileStore(t, dir, opts)
+
+	blocks := randomChain(t, 4)
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+	_, err := s.GetSnapshot()
+	assert.ErrorIs(t, err, ErrSnapshotNotFound)
+
+	addr := types.Address{1}
+	assert.Nil(t, s.PutSnapshot(&StateSnapshot{
+		Height: 1,
+		Hash:   blocks[1].Hash(BlockHasher{}),
+		Data:   map[types.Address]map[string][]byte{addr: {"k": []byte("v")}},
+	}))
+	assert.Equal(t, uint32(2), s.pruned)
+
+	// snapshots must be of a stored block and never go back
+	assert.NotNil(t, s.PutSnapshot(&StateSnapshot{Height: 2, Hash: types.RandomHash()}))
+	assert.NotNil(t, s.PutSnapshot(&StateSnapshot{Height: 0, Hash: blocks[0].Hash(BlockHasher{})}))
+
+	// the blocks pruned need the snapshot
+	assert.NotNil(t, s.Rewind(0))
+	assert.Nil(t, s.Close())
+
+	s = newFileStore(t, dir, opts)
+	defer s.Close()
+	snapshot, err := s.GetSnapshot()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), snapshot.Height)
+	assert.Equal(t, []byte("v"), snapshot.Data[addr]["k"])
+}
+
+func TestFileStoreGenesis(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{})
+	_, err := s.GetGenesis()
+	assert.ErrorIs(t, err, ErrGenesisNotFound)
+
+	g := &Genesis{
+		Timestamp:  1700000000,
+		Validators: []crypto.PublicKey{crypto.GeneratePrivateKey().PublicKey()},
+		Alloc:      map[types.Address]map[string][]byte{types.RandomAddress(): {"k": []byte("v")}},
+		Config:     upgradedConfig(),
+	}
+	assert.Nil(t, s.PutGenesis(g))
+	assert.Nil(t, s.Close())
+
+	s = newFileStore(t, dir, FileStoreOpts{ReadOnly: true})
+	defer s.Close()
+	stored, err := s.GetGenesis()
+	assert.Nil(t, err)
+	assert.Equal(t, g.Hash(), stored.Hash())
+	assert.Equal(t, g.Config, stored.Config)
+	assert.Equal(t, g.Alloc, stored.Alloc)
+}
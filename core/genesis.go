@@ -0,0 +1,242 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/hitenjain14/go-blockchain/crypto"
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// Genesis describes the first block of a network and the state it starts
+// with.
+type Genesis struct {
+	Timestamp int64
+	// Validators are the keys allowed to sign blocks. Any key may sign
+	// when there are none.
+	Validators []crypto.PublicKey
+	// Alloc is the contract storage each address starts with.
+	Alloc map[types.Address]map[string][]byte
+	// Config is the chain config of the network, DefaultChainConfig when
+	// nil.
+	Config *ChainConfig
+}
+
+// genesisFile is the JSON form of Genesis. Keys, addresses and storage
+// are hex encoded.
+//
+//	{
+//		"timestamp": 1700000000,
+//		"config": {
+//			"chainId": 7,
+//			"upgrades": [{"height": 0, "version": 1, "txGasLimit": 1000000}]
+//		},
+//		"validators": ["04a1..."],
+//		"alloc": {
+//			"a1b2...": {"storage": {"01": "2a"}}
+//		}
+//	}
+type genesisFile struct {
+	Timestamp  int64                         `json:"timestamp"`
+	Config     *ChainConfig                  `json:"config"`
+	Validators []string                      `json:"validators"`
+	Alloc      map[string]genesisAccountFile `json:"alloc"`
+}
+
+type genesisAccountFile struct {
+	Storage map[string]string `json:"storage"`
+}
+
+// LoadGenesis reads a genesis from the JSON file at path.
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := ParseGenesis(data)
+	if err != nil {
+		return nil, fmt.Errorf("genesis file %s: %w", path, err)
+	}
+	return g, nil
+}
+
+// ParseGenesis decodes a genesis from its JSON form.
+func ParseGenesis(data []byte) (*Genesis, error) {
+	f := genesisFile{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+
+	g := &Genesis{
+		Timestamp: f.Timestamp,
+		Alloc:     make(map[types.Address]map[string][]byte),
+		Config:    f.Config,
+	}
+
+	if g.Config != nil {
+		if err := g.Config.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range f.Validators {
+		b, err := hex.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("validator %s: %w", v, err)
+		}
+		key, err := crypto.PublicKeyFromBytes(b)
+		if err != nil {
+			return nil, fmt.Errorf("validator %s: %w", v, err)
+		}
+		g.Validators = append(g.Validators, key)
+	}
+
+	for a, account := range f.Alloc {
+		b, err := hex.DecodeString(a)
+		if err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("invalid allocation address %s", a)
+		}
+		storage := make(map[string][]byte)
+		for k, v := range account.Storage {
+			key, err := hex.DecodeString(k)
+			if err != nil {
+				return nil, fmt.Errorf("allocation %s: key %s: %w", a, k, err)
+			}
+			value, err := hex.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("allocation %s: value of key %s: %w", a, k, err)
+			}
+			storage[string(key)] = value
+		}
+		g.Alloc[types.AddressFromBytes(b)] = storage
+	}
+
+	return g, nil
+}
+
+// MarshalJSON encodes g in the form ParseGenesis reads.
+func (g *Genesis) MarshalJSON() ([]byte, error) {
+	f := genesisFile{
+		Timestamp:  g.Timestamp,
+		Config:     g.Config,
+		Validators: []string{},
+		Alloc:      make(map[string]genesisAccountFile, len(g.Alloc)),
+	}
+	for _, v := range g.Validators {
+		f.Validators = append(f.Validators, hex.EncodeToString(v.ToSlice()))
+	}
+	for a, storage := range g.Alloc {
+		account := genesisAccountFile{Storage: make(map[string]string, len(storage))}
+		for k, v := range storage {
+			account.Storage[hex.EncodeToString([]byte(k))] = hex.EncodeToString(v)
+		}
+		f.Alloc[a.String()] = account
+	}
+	return json.Marshal(f)
+}
+
+// Block returns the genesis block. Its data hash commits to the chain ID,
+// validators and allocations, so networks that start from different ones
+// have different genesis hashes.
+func (g *Genesis) Block() *Block {
+	header := &Header{
+		Version:   g.ChainConfig().Rules(0).Version,
+		DataHash:  g.dataHash(),
+		Timestamp: g.Timestamp,
+		Height:    0,
+	}
+
+	b, _ := NewBlock(header, nil)
+	return b
+}
+
+// Hash returns the hash of the genesis block, which identifies the
+// network.
+func (g *Genesis) Hash() types.Hash {
+	return g.Block().Hash(BlockHasher{})
+}
+
+// ChainConfig returns the chain config of the network.
+func (g *Genesis) ChainConfig() *ChainConfig {
+	if g.Config == nil {
+		return DefaultChainConfig()
+	}
+	return g.Config
+}
+
+// storedGenesis returns the genesis s keeps, nil when it keeps none.
+func storedGenesis(s Storage) (*Genesis, error) {
+	gs, ok := s.(GenesisStorage)
+	if !ok {
+		return nil, nil
+	}
+
+	g, err := gs.GetGenesis()
+	if errors.Is(err, ErrGenesisNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading stored genesis: %w", err)
+	}
+	return g, nil
+}
+
+// dataHash hashes the chain ID, the validators in order and the
+// allocations sorted by address and key. It is zero when there are none
+// of them, as it was for chains started before genesis files existed.
+// The upgrade schedule isn't part of it, so that upgrades can be added to
+// a running network.
+func (g *Genesis) dataHash() types.Hash {
+	chainID := g.ChainConfig().ChainID
+	if chainID == 0 && len(g.Validators) == 0 && len(g.Alloc) == 0 {
+		return types.Hash{}
+	}
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, chainID)
+	writeBytes := func(b []byte) {
+		binary.Write(buf, binary.LittleEndian, uint32(len(b)))
+		buf.Write(b)
+	}
+
+	binary.Write(buf, binary.LittleEndian, uint32(len(g.Validators)))
+	for _, v := range g.Validators {
+		writeBytes(v.ToSlice())
+	}
+
+	addrs := make([]types.Address, 0, len(g.Alloc))
+	for a := range g.Alloc {
+		addrs = append(addrs, a)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i].ToSlice(), addrs[j].ToSlice()) < 0
+	})
+
+	binary.Write(buf, binary.LittleEndian, uint32(len(addrs)))
+	for _, a := range addrs {
+		buf.Write(a.ToSlice())
+
+		storage := g.Alloc[a]
+		keys := make([]string, 0, len(storage))
+		for k := range storage {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		binary.Write(buf, binary.LittleEndian, uint32(len(keys)))
+		for _, k := range keys {
+			writeBytes([]byte(k))
+			writeBytes(storage[k])
+		}
+	}
+
+	return sha256.Sum256(buf.Bytes())
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/crypto"
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGenesis(t *testing.T) {
+	key := crypto.GeneratePrivateKey().PublicKey()
+	addr := types.RandomAddress()
+
+	g, err := ParseGenesis([]byte(fmt.Sprintf(`{
+		"timestamp": 1700000000,
+		"validators": [%q],
+		"alloc": {%q: {"storage": {"01": "2a"}}}
+	}`, hex.EncodeToString(key.ToSlice()), addr)))
+	assert.Nil(t, err)
+
+	assert.Equal(t, int64(1700000000), g.Timestamp)
+	assert.Len(t, g.Validators, 1)
+	assert.Equal(t, key.ToSlice(), g.Validators[0].ToSlice())
+	assert.Equal(t, map[types.Address]map[string][]byte{
+		addr: {"\x01": {0x2a}},
+	}, g.Alloc)
+
+	_, err = ParseGenesis([]byte(`{"validators": ["zz"]}`))
+	assert.NotNil(t, err)
+	_, err = ParseGenesis([]byte(`{"alloc": {"0102": {}}}`))
+	assert.NotNil(t, err)
+	_, err = ParseGenesis([]byte(`{"config": {"chainId": 7, "upgrades": []}}`))
+	assert.NotNil(t, err)
+
+	g, err = ParseGenesis([]byte(`{"config": {"chainId": 7, "upgrades": [
+		{"height": 0, "version": 1, "txGasLimit": 1000},
+		{"height": 10, "version": 2, "txGasLimit": 2000}
+	]}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(7), g.Config.ChainID)
+	assert.Equal(t, Rules{Version: 2, TxGasLimit: 2000}, g.Config.Rules(10))
+}
+
+func TestLoadGenesis(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"timestamp": 42}`), 0o644))
+
+	g, err := LoadGenesis(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), g.Timestamp)
+
+	_, err = LoadGenesis(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NotNil(t, err)
+}
+
+func TestGenesisHash(t *testing.T) {
+	empty := &Genesis{}
+	assert.Equal(t, types.Hash{}, empty.Block().DataHash)
+	assert.Equal(t, empty.Hash(), (&Genesis{}).Hash())
+
+	addr := types.RandomAddress()
+	alloc := &Genesis{Alloc: map[types.Address]map[string][]byte{addr: {"k": []byte("v")}}}
+	other := &Genesis{Alloc: map[types.Address]map[string][]byte{addr: {"k": []byte("w")}}}
+	validators := &Genesis{Validators: []crypto.PublicKey{crypto.GeneratePrivateKey().PublicKey()}}
+	later := &Genesis{Timestamp: 1}
+
+	hashes := map[types.Hash]bool{}
+	for _, g := range []*Genesis{empty, alloc, other, validators, later} {
+		hashes[g.Hash()] = true
+	}
+	assert.Len(t, hashes, 5)
+}
+
+func TestBlockchainFromGenesis(t *testing.T) {
+	validator := crypto.GeneratePrivateKey()
+	addr := types.RandomAddress()
+	g := &Genesis{
+		Timestamp:  1700000000,
+		Validators: []crypto.PublicKey{validator.PublicKey()},
+		Alloc:      map[types.Address]map[string][]byte{addr: {"k": []byte("v")}},
+	}
+
+	store := NewMemoryStore()
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), store, g)
+	assert.Nil(t, err)
+	assert.Equal(t, g.Hash(), bc.GenesisHash())
+
+	v, ok := bc.State().Get(addr, []byte("k"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("v"), v)
+
+	// only a validator of the genesis may sign blocks
+	assert.NotNil(t, bc.AddBlock(randomBlock(t, 1, g.Hash())))
+
+	b, err := NewBlockFromPrevHeader(g.ChainConfig(), g.Block().Header, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(validator))
+	assert.Nil(t, bc.AddBlock(b))
+
+	// the allocations are there again after reloading
+	bc, err = NewBlockchainFromGenesis(log.NewNopLogger(), store, g)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), bc.Height())
+	_, ok = bc.State().Get(addr, []byte("k"))
+	assert.True(t, ok)
+
+	// and a store of another network is refused
+	_, err = NewBlockchainFromGenesis(log.NewNopLogger(), store, &Genesis{})
+	assert.NotNil(t, err)
+}
+
+func TestBlockchainKeepsGenesis(t *testing.T) {
+	// the upgrade schedule isn't part of the genesis hash
+	g := &Genesis{Config: &ChainConfig{Upgrades: upgradedConfig().Upgrades}}
+	assert.Equal(t, (&Genesis{}).Hash(), g.Hash())
+
+	store := NewMemoryStore()
+	_, err := NewBlockchainFromGenesis(log.NewNopLogger(), store, g)
+	assert.Nil(t, err)
+
+	// resuming without the genesis file follows the stored rules
+	bc, err := NewBlockchainFromGenesis(log.NewNopLogger(), store, &Genesis{})
+	assert.Nil(t, err)
+	assert.Equal(t, g.Config, bc.Config())
+	bc, err = NewBlockchainWithStorage(log.NewNopLogger(), store, g.Block())
+	assert.Nil(t, err)
+	assert.Equal(t, g.Config, bc.Config())
+
+	// upgrades may be added but not changed
+	changed := &Genesis{Config: &ChainConfig{Upgrades: []Upgrade{
+		g.Config.Upgrades[0],
+		{Height: 3, Rules: g.Config.Upgrades[1].Rules},
+	}}}
+	_, err = NewBlockchainFromGenesis(log.NewNopLogger(), store, changed)
+	assert.NotNil(t, err)
+
+	added := &Genesis{Config: &ChainConfig{Upgrades: append(
+		append([]Upgrade{}, g.Config.Upgrades...),
+		Upgrade{Height: 10, Rules: Rules{Version: 3, TxGasLimit: 1}},
+	)}}
+	_, err = NewBlockchainFromGenesis(log.NewNopLogger(), store, added)
+	assert.Nil(t, err)
+	bc, err = NewBlockchainFromGenesis(log.NewNopLogger(), store, &Genesis{})
+	assert.Nil(t, err)
+	assert.Equal(t, added.Config, bc.Config())
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// TxLocation is where a transaction was included in the chain.
+type TxLocation struct {
+	Height uint32
+	// Index is the position of the transaction in its block.
+	Index int
+}
+
+// TxIndex locates the transactions of applied blocks by hash and by
+// sender. Blocks are removed again in the reverse order they were added.
+type TxIndex struct {
+	lock sync.RWMutex
+	// every inclusion of a transaction, oldest first, so that removing a
+	// block uncovers an earlier one
+	byHash   map[types.Hash][]TxLocation
+	bySender map[types.Address][]TxLocation // oldest first
+}
+
+func NewTxIndex() *TxIndex {
+	return &TxIndex{
+		byHash:   make(map[types.Hash][]TxLocation),
+		bySender: make(map[types.Address][]TxLocation),
+	}
+}
+
+func (idx *TxIndex) Add(b *Block) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	for i, tx := range b.Transactions {
+		loc := TxLocation{Height: b.Height, Index: i}
+		hash := tx.Hash(TxHasher{})
+		idx.byHash[hash] = append(idx.byHash[hash], loc)
+
+		sender := tx.From.Address()
+		idx.bySender[sender] = append(idx.bySender[sender], loc)
+	}
+}
+
+// Remove drops the transactions of b, which must be the last block
+// added.
+func (idx *TxIndex) Remove(b *Block) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		tx := b.Transactions[i]
+
+		hash := tx.Hash(TxHasher{})
+		if locs := idx.byHash[hash]; len(locs) > 1 {
+			idx.byHash[hash] = locs[:len(locs)-1]
+		} else {
+			delete(idx.byHash, hash)
+		}
+
+		sender := tx.From.Address()
+		if locs := idx.bySender[sender]; len(locs) > 1 {
+			idx.bySender[sender] = locs[:len(locs)-1]
+		} else {
+			delete(idx.bySender, sender)
+		}
+	}
+}
+
+// Get returns the location of the latest inclusion of the transaction
+// with the given hash.
+func (idx *TxIndex) Get(hash types.Hash) (TxLocation, bool) {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	locs := idx.byHash[hash]
+	if len(locs) == 0 {
+		return TxLocation{}, false
+	}
+	return locs[len(locs)-1], true
+}
+
+// BySender returns up to limit locations of transactions sent by addr,
+// newest first, skipping the offset newest ones.
+func (idx *TxIndex) BySender(addr types.Address, offset, limit int) []TxLocation {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	locs := idx.bySender[addr]
+	page := []TxLocation{}
+	for i := len(locs) - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, locs[i])
+	}
+	return page
+}
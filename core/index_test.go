@@ -0,0 +1,62 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/hitenjain14/go-blockchain/crypto"
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxIndexBySender(t *testing.T) {
+	idx := NewTxIndex()
+	privKey := crypto.GeneratePrivateKey()
+
+	for height := uint32(0); height < 3; height++ {
+		txx := []*Transaction{}
+		for i := 0; i < 2; i++ {
+			tx := NewTransaction(types.RandomBytes(8))
+			assert.Nil(t, tx.Sign(privKey))
+			txx = append(txx, tx)
+		}
+		txx = append(txx, randomSignedTransaction(t))
+
+		b, err := NewBlock(&Header{Height: height}, txx)
+		assert.Nil(t, err)
+		idx.Add(b)
+	}
+
+	sender := privKey.PublicKey().Address()
+	assert.Equal(t, []TxLocation{{2, 1}, {2, 0}, {1, 1}}, idx.BySender(sender, 0, 3))
+	assert.Equal(t, []TxLocation{{1, 0}, {0, 1}, {0, 0}}, idx.BySender(sender, 3, 5))
+	assert.Empty(t, idx.BySender(sender, 6, 5))
+	assert.Empty(t, idx.BySender(types.RandomAddress(), 0, 5))
+}
+
+func TestTxIndexRemove(t *testing.T) {
+	idx := NewTxIndex()
+	tx := randomSignedTransaction(t)
+
+	first, err := NewBlock(&Header{Height: 0}, []*Transaction{tx})
+	assert.Nil(t, err)
+	second, err := NewBlock(&Header{Height: 1}, []*Transaction{tx})
+	assert.Nil(t, err)
+	idx.Add(first)
+	idx.Add(second)
+
+	loc, ok := idx.Get(tx.Hash(TxHasher{}))
+	assert.True(t, ok)
+	assert.Equal(t, TxLocation{1, 0}, loc)
+
+	// removing a block uncovers the earlier inclusion
+	idx.Remove(second)
+	loc, ok = idx.Get(tx.Hash(TxHasher{}))
+	assert.True(t, ok)
+	assert.Equal(t, TxLocation{0, 0}, loc)
+	assert.Equal(t, []TxLocation{{0, 0}}, idx.BySender(tx.From.Address(), 0, 5))
+
+	idx.Remove(first)
+	_, ok = idx.Get(tx.Hash(TxHasher{}))
+	assert.False(t, ok)
+	assert.Empty(t, idx.BySender(tx.From.Address(), 0, 5))
+}
@@ -0,0 +1,298 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hitenjain14/go-blockchain/kv"
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+var (
+	kvBlockPrefix   = []byte("b") // height -> gob encoded block
+	kvHashPrefix    = []byte("h") // block hash -> height
+	kvReceiptPrefix = []byte("r") // height -> gob encoded receipts
+	kvUndoPrefix    = []byte("u") // height -> gob encoded state changes
+	kvStatePrefix   = []byte("s") // address and key -> value
+	kvCountKey      = []byte("n") // number of stored blocks
+)
+
+// stateStorage is a Storage that also keeps the state, and stores every
+// block together with the receipts and state changes of its
+// transactions.
+type stateStorage interface {
+	Storage
+	// keepsState reports whether the store keeps the state at all.
+	keepsState() bool
+	// putBlock stores b with its receipts, the values its transactions
+	// wrote and the changes that undo them, all at once.
+	putBlock(b *Block, receipts []*Receipt, writes map[types.Address]map[string][]byte, undo []stateChange) error
+	// state returns the state after the last stored block.
+	state() (map[types.Address]map[string][]byte, error)
+	// blockState returns the receipts and state changes stored with the
+	// block with height.
+	blockState(height uint32) ([]*Receipt, []stateChange, error)
+}
+
+// keptState returns s as a stateStorage if it keeps the state.
+func keptState(s Storage) (stateStorage, bool) {
+	ss, ok := s.(stateStorage)
+	if !ok || !ss.keepsState() {
+		return nil, false
+	}
+	return ss, true
+}
+
+// storedChange is the stored form of a stateChange.
+type storedChange struct {
+	Addr    types.Address
+	Key     string
+	Prev    []byte
+	Existed bool
+}
+
+// KVStore is a Storage on top of a kv.Store that also keeps the state.
+// A block, its hash, the receipts of its transactions and the state
+// changes they made are written in one batch, and Rewind takes blocks
+// and their state changes back in one batch, so a crash never leaves the
+// blocks and the state out of step. A chain on a KVStore resumes from the
+// stored state instead of running the stored blocks again.
+type KVStore struct {
+	lock  sync.RWMutex
+	db    kv.Store
+	count uint32
+}
+
+func NewKVStore(db kv.Store) (*KVStore, error) {
+	s := &KVStore{db: db}
+
+	v, err := db.Get(kvCountKey)
+	if errors.Is(err, kv.ErrNotFound) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(v) != 4 {
+		return nil, fmt.Errorf("invalid block count of %d bytes", len(v))
+	}
+	s.count = binary.BigEndian.Uint32(v)
+
+	return s, nil
+}
+
+// Put stores b without receipts or state changes.
+func (s *KVStore) Put(b *Block) error {
+	return s.putBlock(b, nil, nil, nil)
+}
+
+func (s *KVStore) keepsState() bool {
+	return true
+}
+
+func (s *KVStore) putBlock(b *Block, receipts []*Receipt, writes map[types.Address]map[string][]byte, undo []stateChange) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if b.Height != s.count {
+		return fmt.Errorf("block with %d height can't be stored after height %d", b.Height, int64(s.count)-1)
+	}
+
+	batch := kv.NewBatch()
+
+	buf := &bytes.Buffer{}
+	if err := b.Encode(NewGobBlockEncoder(buf)); err != nil {
+		return err
+	}
+	batch.Put(kvBlockKey(b.Height), buf.Bytes())
+	batch.Put(kvHashKey(b.Hash(BlockHasher{})), heightBytes(b.Height))
+
+	buf = &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(receipts); err != nil {
+		return err
+	}
+	batch.Put(kvHeightKey(kvReceiptPrefix, b.Height), buf.Bytes())
+
+	changes := make([]storedChange, len(undo))
+	for i, c := range undo {
+		changes[i] = storedChange{Addr: c.addr, Key: c.key, Prev: c.prev, Existed: c.existed}
+	}
+	buf = &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(changes); err != nil {
+		return err
+	}
+	batch.Put(kvHeightKey(kvUndoPrefix, b.Height), buf.Bytes())
+
+	for addr, storage := range writes {
+		for k, v := range storage {
+			batch.Put(kvStateKey(addr, k), v)
+		}
+	}
+
+	batch.Put(kvCountKey, heightBytes(b.Height+1))
+	if err := s.db.Write(batch); err != nil {
+		return err
+	}
+
+	s.count++
+	return nil
+}
+
+func (s *KVStore) Has(hash types.Hash) bool {
+	return s.db.Has(kvHashKey(hash))
+}
+
+func (s *KVStore) GetByHash(hash types.Hash) (*Block, error) {
+	v, err := s.db.Get(kvHashKey(hash))
+	if errors.Is(err, kv.ErrNotFound) {
+		return nil, fmt.Errorf("%w: hash %s", ErrBlockNotFound, hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByHeight(binary.BigEndian.Uint32(v))
+}
+
+func (s *KVStore) GetByHeight(height uint32) (*Block, error) {
+	v, err := s.db.Get(kvBlockKey(height))
+	if errors.Is(err, kv.ErrNotFound) {
+		return nil, fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Block)
+	if err := b.Decode(NewGobBlockDecoder(bytes.NewReader(v))); err != nil {
+		return nil, fmt.Errorf("decoding block with %d height: %w", height, err)
+	}
+	return b, nil
+}
+
+func (s *KVStore) GetHeader(height uint32) (*Header, error) {
+	b, err := s.GetByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return b.Header, nil
+}
+
+func (s *KVStore) Iterate(from, to uint32, fn func(*Block) error) error {
+	return iterateByHeight(s.GetByHeight, from, to, fn)
+}
+
+// Rewind removes the blocks above height and reverts the state changes
+// they made, in one batch.
+func (s *KVStore) Rewind(height uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if height+1 >= s.count {
+		return nil
+	}
+
+	// undone newest first, so that each key ends up with the value it had
+	// before the oldest removed block
+	batch := kv.NewBatch()
+	for h := s.count - 1; h > height; h-- {
+		b, err := s.GetByHeight(h)
+		if err != nil {
+			return err
+		}
+		_, undo, err := s.blockState(h)
+		if err != nil {
+			return err
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			c := undo[i]
+			if c.existed {
+				batch.Put(kvStateKey(c.addr, c.key), c.prev)
+			} else {
+				batch.Delete(kvStateKey(c.addr, c.key))
+			}
+		}
+
+		batch.Delete(kvBlockKey(h))
+		batch.Delete(kvHashKey(b.Hash(BlockHasher{})))
+		batch.Delete(kvHeightKey(kvReceiptPrefix, h))
+		batch.Delete(kvHeightKey(kvUndoPrefix, h))
+	}
+	batch.Put(kvCountKey, heightBytes(height+1))
+	if err := s.db.Write(batch); err != nil {
+		return err
+	}
+
+	s.count = height + 1
+	return nil
+}
+
+func (s *KVStore) state() (map[types.Address]map[string][]byte, error) {
+	data := make(map[types.Address]map[string][]byte)
+	err := s.db.Iterate(kvStatePrefix, func(key, value []byte) error {
+		if len(key) < len(kvStatePrefix)+20 {
+			return fmt.Errorf("invalid state key %x", key)
+		}
+		addr := types.AddressFromBytes(key[len(kvStatePrefix) : len(kvStatePrefix)+20])
+		storage, ok := data[addr]
+		if !ok {
+			storage = make(map[string][]byte)
+			data[addr] = storage
+		}
+		storage[string(key[len(kvStatePrefix)+20:])] = value
+		return nil
+	})
+	return data, err
+}
+
+func (s *KVStore) blockState(height uint32) ([]*Receipt, []stateChange, error) {
+	v, err := s.db.Get(kvHeightKey(kvReceiptPrefix, height))
+	if err != nil {
+		return nil, nil, fmt.Errorf("receipts of block with %d height: %w", height, err)
+	}
+	receipts := []*Receipt{}
+	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&receipts); err != nil {
+		return nil, nil, fmt.Errorf("decoding receipts of block with %d height: %w", height, err)
+	}
+
+	v, err = s.db.Get(kvHeightKey(kvUndoPrefix, height))
+	if err != nil {
+		return nil, nil, fmt.Errorf("state changes of block with %d height: %w", height, err)
+	}
+	changes := []storedChange{}
+	if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&changes); err != nil {
+		return nil, nil, fmt.Errorf("decoding state changes of block with %d height: %w", height, err)
+	}
+	undo := make([]stateChange, len(changes))
+	for i, c := range changes {
+		undo[i] = stateChange{addr: c.Addr, key: c.Key, prev: c.Prev, existed: c.Existed}
+	}
+
+	return receipts, undo, nil
+}
+
+func kvBlockKey(height uint32) []byte {
+	return kvHeightKey(kvBlockPrefix, height)
+}
+
+func kvHeightKey(prefix []byte, height uint32) []byte {
+	return append(append([]byte{}, prefix...), heightBytes(height)...)
+}
+
+func kvHashKey(hash types.Hash) []byte {
+	return append(append([]byte{}, kvHashPrefix...), hash.ToSlice()...)
+}
+
+func kvStateKey(addr types.Address, key string) []byte {
+	buf := append(append([]byte{}, kvStatePrefix...), addr.ToSlice()...)
+	return append(buf, key...)
+}
+
+func heightBytes(height uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, height)
+	return buf
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/kv"
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newKVStore(t *testing.T, dir string) (*KVStore, *kv.DB) {
+	db, err := kv.Open(dir, kv.Options{})
+	assert.Nil(t, err)
+	store, err := NewKVStore(db)
+	assert.Nil(t, err)
+	return store, db
+}
+
+func TestKVStorePut(t *testing.T) {
+	store, db := newKVStore(t, t.TempDir())
+	defer db.Close()
+
+	blocks := randomChain(t, 3)
+	for _, b := range blocks {
+		assert.Nil(t, store.Put(b))
+	}
+	assert.NotNil(t, store.Put(blocks[2]))
+
+	b, err := store.GetByHash(blocks[1].Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), b.Height)
+	assert.True(t, store.Has(blocks[2].Hash(BlockHasher{})))
+
+	assert.Nil(t, store.Rewind(0))
+	_, err = store.GetByHeight(1)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+	assert.False(t, store.Has(blocks[2].Hash(BlockHasher{})))
+	assert.Nil(t, store.Put(blocks[1]))
+}
+
+func TestBlockchainOnKVStore(t *testing.T) {
+	dir := t.TempDir()
+	genesis := randomBlock(t, 0, types.Hash{})
+
+	store, db := newKVStore(t, dir)
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+
+	txA, txB := storeTx(t, 1), storeTx(t, 2)
+	a1 := signedBlock(t, 1, getPrevBlockHash(t, bc, 1), txA)
+	assert.Nil(t, bc.AddBlock(a1))
+
+	// the state is stored with the block
+	data, err := store.state()
+	assert.Nil(t, err)
+	assert.Contains(t, data[txA.From.Address()], string(wordBytes(big.NewInt(1))))
+
+	// a reorg reverts the stored state of the blocks it takes off
+	b1 := signedBlock(t, 1, a1.PrevBlockHash, txB)
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 2, b1.Hash(BlockHasher{}))))
+	data, err = store.state()
+	assert.Nil(t, err)
+	assert.NotContains(t, data, txA.From.Address())
+	assert.Contains(t, data[txB.From.Address()], string(wordBytes(big.NewInt(2))))
+	assert.Nil(t, db.Close())
+
+	// a reopened chain resumes from the stored state and receipts
+	store, db = newKVStore(t, dir)
+	defer db.Close()
+	bc, err = NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), bc.Height())
+	assert.False(t, hasStored(bc, txA, 1))
+	assert.True(t, hasStored(bc, txB, 2))
+	_, err = bc.GetReceipt(txB.Hash(TxHasher{}))
+	assert.Nil(t, err)
+
+	assert.Nil(t, bc.AddBlock(randomBlock(t, 3, getPrevBlockHash(t, bc, 3))))
+}
+
+type failingKV struct {
+	kv.Store
+	fail bool
+}
+
+func (s *failingKV) Write(b *kv.Batch) error {
+	if s.fail {
+		return fmt.Errorf("disk full")
+	}
+	return s.Store.Write(b)
+}
+
+func TestBlockchainKVStoreFailure(t *testing.T) {
+	db, err := kv.Open(t.TempDir(), kv.Options{})
+	assert.Nil(t, err)
+	defer db.Close()
+
+	fdb := &failingKV{Store: db}
+	store, err := NewKVStore(fdb)
+	assert.Nil(t, err)
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+
+	fdb.fail = true
+	tx := storeTx(t, 1)
+	assert.NotNil(t, bc.AddBlock(signedBlock(t, 1, getPrevBlockHash(t, bc, 1), tx)))
+
+	// neither the chain nor its state moved ahead of the store
+	assert.Equal(t, uint32(0), bc.Height())
+	assert.False(t, hasStored(bc, tx, 1))
+	data, err := store.state()
+	assert.Nil(t, err)
+	assert.Empty(t, data)
+}
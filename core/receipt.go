@@ -0,0 +1,42 @@
+package core
+
+import (
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// Receipt records the outcome of running a transaction's program when
+// its block was applied.
+type Receipt struct {
+	TxHash  types.Hash
+	Height  uint32
+	GasUsed uint64
+	// Err holds the error the program failed with, empty on success.
+	Err string
+}
+
+func (r *Receipt) Failed() bool {
+	return r.Err != ""
+}
+
+// executeBlock runs the program of every transaction of b in order
+// against state. A program that fails leaves state untouched and is
+// recorded as a failed receipt.
+func executeBlock(b *Block, state *State, gasLimit uint64) []*Receipt {
+	receipts := make([]*Receipt, len(b.Transactions))
+
+	for i, tx := range b.Transactions {
+		vm := NewVM(tx.Data, NewExecContext(b.Header, tx, state), gasLimit)
+		err := vm.Run()
+
+		receipts[i] = &Receipt{
+			TxHash:  tx.Hash(TxHasher{}),
+			Height:  b.Height,
+			GasUsed: vm.GasUsed(),
+		}
+		if err != nil {
+			receipts[i].Err = err.Error()
+		}
+	}
+
+	return receipts
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Blocks are written to files as records: a 4 byte big-endian payload
+// length, the crc32 of the payload and the gob encoded block.
+const recordHeaderSize = 8
+
+func encodeRecord(b *Block) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.Write(make([]byte, recordHeaderSize))
+	if err := b.Encode(NewGobBlockEncoder(buf)); err != nil {
+		return nil, err
+	}
+
+	return frameRecord(buf.Bytes()), nil
+}
+
+// frameRecord fills in the header of record, whose first recordHeaderSize
+// bytes are reserved for it.
+func frameRecord(record []byte) []byte {
+	payload := record[recordHeaderSize:]
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return record
+}
+
+// readRecord decodes the block stored at offset of a segment of the
+// given size and returns it together with its payload length.
+func readRecord(r io.ReaderAt, offset, size int64) (*Block, uint32, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+
+	if offset+recordHeaderSize+int64(length) > size {
+		return nil, 0, fmt.Errorf("record at offset %d is truncated", offset)
+	}
+
+	payload := make([]byte, length)
+	if _, err := r.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return nil, 0, err
+	}
+
+	b, err := decodeRecord(header, payload)
+	if err != nil {
+		return nil, 0, fmt.Errorf("record at offset %d: %w", offset, err)
+	}
+	return b, length, nil
+}
+
+// readNextRecord decodes the next record of a stream. It returns io.EOF
+// when r ends right before a record.
+func readNextRecord(r io.Reader, maxLength uint32) (*Block, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxLength {
+		return nil, fmt.Errorf("record of %d bytes exceeds limit of %d bytes", length, maxLength)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return decodeRecord(header, payload)
+}
+
+func decodeRecord(header, payload []byte) (*Block, error) {
+	if err := checkRecord(header, payload); err != nil {
+		return nil, err
+	}
+
+	b := new(Block)
+	if err := b.Decode(NewGobBlockDecoder(bytes.NewReader(payload))); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func checkRecord(header, payload []byte) error {
+	if binary.BigEndian.Uint32(header[0:4]) != uint32(len(payload)) {
+		return fmt.Errorf("invalid record length")
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return fmt.Errorf("invalid record checksum")
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// defaultSnapshotInterval is the number of blocks between two state
+// snapshots.
+const defaultSnapshotInterval = 100
+
+// StateSnapshot is the state right after the block with Height and Hash
+// was applied.
+type StateSnapshot struct {
+	Height uint32
+	Hash   types.Hash
+	Data   map[types.Address]map[string][]byte
+}
+
+// encodeSnapshot returns s as a record.
+func encodeSnapshot(s *StateSnapshot) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.Write(make([]byte, recordHeaderSize))
+	if err := gob.NewEncoder(buf).Encode(s); err != nil {
+		return nil, err
+	}
+	return frameRecord(buf.Bytes()), nil
+}
+
+// decodeSnapshot reads a snapshot written by encodeSnapshot.
+func decodeSnapshot(data []byte) (*StateSnapshot, error) {
+	if len(data) < recordHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	header, payload := data[:recordHeaderSize], data[recordHeaderSize:]
+	if err := checkRecord(header, payload); err != nil {
+		return nil, err
+	}
+
+	s := new(StateSnapshot)
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// storedSnapshot returns the snapshot kept by the store, nil when it
+// keeps none.
+func (bc *Blockchain) storedSnapshot() (*StateSnapshot, error) {
+	ss, ok := bc.store.(SnapshotStorage)
+	if !ok {
+		return nil, nil
+	}
+
+	snapshot, err := ss.GetSnapshot()
+	if errors.Is(err, ErrSnapshotNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// snapshotState hands the store a snapshot of the state after the last
+// final block, at most once every snapshotInterval blocks. The store may
+// then prune the blocks up to that one. A failed snapshot only delays
+// pruning, so it is logged rather than returned. The caller must hold
+// addLock, so that no other snapshot is taken meanwhile.
+func (bc *Blockchain) snapshotState() {
+	ss, ok := bc.store.(SnapshotStorage)
+	if !ok {
+		return
+	}
+
+	bc.lock.RLock()
+	tip := len(bc.chain) - 1
+	final := tip - int(bc.finalityDepth)
+	if final < 0 || uint32(final) < bc.nextSnapshot {
+		bc.lock.RUnlock()
+		return
+	}
+
+	// the blocks after the final one still have their undo
+	state := bc.state.copy()
+	for i := tip; i > final; i-- {
+		state.revert(bc.chain[i].undo)
+	}
+	snapshot := &StateSnapshot{
+		Height: uint32(final),
+		Hash:   bc.chain[final].hash,
+		Data:   state.data,
+	}
+	bc.lock.RUnlock()
+
+	if err := ss.PutSnapshot(snapshot); err != nil {
+		bc.logger.Log("msg", "storing state snapshot failed",
+			"height", snapshot.Height,
+			"err", err,
+		)
+		return
+	}
+
+	bc.lock.Lock()
+	bc.nextSnapshot = snapshot.Height + bc.snapshotInterval
+	bc.lock.Unlock()
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// State holds the key/value storage of every contract, namespaced by
+// contract address.
+type State struct {
+	lock sync.RWMutex
+	data map[types.Address]map[string][]byte
+
+	journaling bool
+	journal    []stateChange
+}
+
+// stateChange is the value a key held before a commit overwrote it, so
+// that the commit can be reverted.
+type stateChange struct {
+	addr    types.Address
+	key     string
+	prev    []byte
+	existed bool
+}
+
+func NewState() *State {
+	return &State{
+		data: make(map[types.Address]map[string][]byte),
+	}
+}
+
+func (s *State) Get(addr types.Address, key []byte) ([]byte, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	v, ok := s.data[addr][string(key)]
+	return v, ok
+}
+
+// Commit applies a set of writes to the storage of addr at once.
+func (s *State) Commit(addr types.Address, writes map[string][]byte) {
+	if len(writes) == 0 {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	store, ok := s.data[addr]
+	if !ok {
+		store = make(map[string][]byte)
+		s.data[addr] = store
+	}
+	for k, v := range writes {
+		if s.journaling {
+			prev, existed := store[k]
+			s.journal = append(s.journal, stateChange{addr: addr, key: k, prev: prev, existed: existed})
+		}
+		store[k] = v
+	}
+}
+
+// copy returns a state with the same contents as s.
+func (s *State) copy() *State {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	c := NewState()
+	for addr, store := range s.data {
+		m := make(map[string][]byte, len(store))
+		for k, v := range store {
+			m[k] = v
+		}
+		c.data[addr] = m
+	}
+	return c
+}
+
+// restore replaces the contents of s by data.
+func (s *State) restore(data map[types.Address]map[string][]byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data = make(map[types.Address]map[string][]byte, len(data))
+	for addr, store := range data {
+		// decoding may leave empty storage nil
+		if store == nil {
+			store = make(map[string][]byte)
+		}
+		s.data[addr] = store
+	}
+}
+
+// startJournal makes the state record the changes of every Commit until
+// stopJournal is called.
+func (s *State) startJournal() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.journaling = true
+	s.journal = nil
+}
+
+// stopJournal returns the changes recorded since startJournal.
+func (s *State) stopJournal() []stateChange {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	changes := s.journal
+	s.journaling = false
+	s.journal = nil
+	return changes
+}
+
+// revert undoes changes recorded by a journal.
+func (s *State) revert(changes []stateChange) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		if c.existed {
+			s.data[c.addr][c.key] = c.prev
+		} else {
+			delete(s.data[c.addr], c.key)
+		}
+	}
+}
@@ -1,16 +1,181 @@
 package core
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// ErrBlockNotFound is returned by a Storage asked for a block it doesn't
+// hold.
+var ErrBlockNotFound = errors.New("block not found")
+
+// ErrBlockPruned is returned by a Storage asked for a block whose
+// transactions were pruned. Its header can still be read.
+var ErrBlockPruned = errors.New("block body pruned")
+
 type Storage interface {
 	Put(*Block) error
+	Has(types.Hash) bool
+	GetByHash(types.Hash) (*Block, error)
+	GetByHeight(uint32) (*Block, error)
+	// GetHeader returns the header of the block with the given height,
+	// also when its transactions were pruned.
+	GetHeader(uint32) (*Header, error)
+	// Iterate calls fn with every stored block whose height is in
+	// [from, to], in ascending order. It stops at the first error
+	// returned by fn and returns it.
+	Iterate(from, to uint32, fn func(*Block) error) error
+	// Rewind removes every block above height, so that the next block
+	// stored has height+1.
+	Rewind(height uint32) error
+}
+
+// ErrSnapshotNotFound is returned by a SnapshotStorage that holds no
+// state snapshot.
+var ErrSnapshotNotFound = errors.New("state snapshot not found")
+
+// SnapshotStorage is a Storage that also keeps a snapshot of the state.
+// The effects of blocks up to the snapshot don't depend on their
+// transactions any more, so only those blocks may be pruned.
+type SnapshotStorage interface {
+	Storage
+	// PutSnapshot replaces the state snapshot by s, which must belong to
+	// a stored block.
+	PutSnapshot(s *StateSnapshot) error
+	GetSnapshot() (*StateSnapshot, error)
+}
+
+// ErrGenesisNotFound is returned by a GenesisStorage that holds no
+// genesis.
+var ErrGenesisNotFound = errors.New("genesis not found")
+
+// GenesisStorage is a Storage that also keeps the genesis the chain was
+// started from, so that the chain can be resumed with its rules without
+// the genesis file.
+type GenesisStorage interface {
+	Storage
+	PutGenesis(*Genesis) error
+	GetGenesis() (*Genesis, error)
 }
 
 type MemoryStore struct {
+	lock    sync.RWMutex
+	blocks  []*Block
+	hashes  map[types.Hash]uint32
+	genesis *Genesis
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{}
+	return &MemoryStore{
+		blocks: []*Block{},
+		hashes: make(map[types.Hash]uint32),
+	}
 }
 
 func (s *MemoryStore) Put(b *Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if b.Height != uint32(len(s.blocks)) {
+		return fmt.Errorf("block with %d height can't be stored after height %d", b.Height, len(s.blocks)-1)
+	}
+
+	s.blocks = append(s.blocks, b)
+	s.hashes[b.Hash(BlockHasher{})] = b.Height
+	return nil
+}
+
+func (s *MemoryStore) Has(hash types.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.hashes[hash]
+	return ok
+}
+
+func (s *MemoryStore) GetByHash(hash types.Hash) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	height, ok := s.hashes[hash]
+	if !ok {
+		return nil, fmt.Errorf("%w: hash %s", ErrBlockNotFound, hash)
+	}
+	return s.blocks[height], nil
+}
+
+func (s *MemoryStore) GetByHeight(height uint32) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if int(height) >= len(s.blocks) {
+		return nil, fmt.Errorf("%w: height %d", ErrBlockNotFound, height)
+	}
+	return s.blocks[height], nil
+}
+
+func (s *MemoryStore) GetHeader(height uint32) (*Header, error) {
+	b, err := s.GetByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return b.Header, nil
+}
+
+func (s *MemoryStore) Iterate(from, to uint32, fn func(*Block) error) error {
+	return iterateByHeight(s.GetByHeight, from, to, fn)
+}
+
+func (s *MemoryStore) PutGenesis(g *Genesis) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.genesis = g
+	return nil
+}
+
+func (s *MemoryStore) GetGenesis() (*Genesis, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.genesis == nil {
+		return nil, ErrGenesisNotFound
+	}
+	return s.genesis, nil
+}
+
+func (s *MemoryStore) Rewind(height uint32) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for len(s.blocks) > int(height)+1 {
+		b := s.blocks[len(s.blocks)-1]
+		delete(s.hashes, b.Hash(BlockHasher{}))
+		s.blocks = s.blocks[:len(s.blocks)-1]
+	}
+	return nil
+}
+
+// iterateByHeight implements Storage.Iterate on top of a height lookup.
+func iterateByHeight(get func(uint32) (*Block, error), from, to uint32, fn func(*Block) error) error {
+	for height := from; height <= to; height++ {
+		b, err := get(height)
+		if errors.Is(err, ErrBlockNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+		// don't wrap around when to is the highest height
+		if height == to {
+			break
+		}
+	}
 	return nil
 }
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStorageReads(t *testing.T, s Storage) {
+	blocks := randomChain(t, 4)
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+
+	for i, b := range blocks {
+		hash := b.Hash(BlockHasher{})
+		assert.True(t, s.Has(hash))
+
+		byHeight, err := s.GetByHeight(uint32(i))
+		assert.Nil(t, err)
+		assert.Equal(t, hash, byHeight.Hash(BlockHasher{}))
+
+		byHash, err := s.GetByHash(hash)
+		assert.Nil(t, err)
+		assert.Equal(t, b.Height, byHash.Height)
+		assert.Len(t, byHash.Transactions, len(b.Transactions))
+	}
+
+	assert.False(t, s.Has(types.RandomHash()))
+	_, err := s.GetByHash(types.RandomHash())
+	assert.True(t, errors.Is(err, ErrBlockNotFound))
+	_, err = s.GetByHeight(4)
+	assert.True(t, errors.Is(err, ErrBlockNotFound))
+
+	heights := []uint32{}
+	assert.Nil(t, s.Iterate(1, 10, func(b *Block) error {
+		heights = append(heights, b.Height)
+		return nil
+	}))
+	assert.Equal(t, []uint32{1, 2, 3}, heights)
+
+	stop := errors.New("stop")
+	heights = []uint32{}
+	assert.Equal(t, stop, s.Iterate(0, 3, func(b *Block) error {
+		heights = append(heights, b.Height)
+		if b.Height == 1 {
+			return stop
+		}
+		return nil
+	}))
+	assert.Equal(t, []uint32{0, 1}, heights)
+}
+
+func testStorageRewind(t *testing.T, s Storage) {
+	blocks := randomChain(t, 4)
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+
+	assert.Nil(t, s.Rewind(1))
+	assert.Nil(t, s.Rewind(3))
+
+	_, err := s.GetByHeight(2)
+	assert.True(t, errors.Is(err, ErrBlockNotFound))
+	assert.False(t, s.Has(blocks[2].Hash(BlockHasher{})))
+	assert.True(t, s.Has(blocks[1].Hash(BlockHasher{})))
+
+	// storing continues after the new tip
+	other := randomBlock(t, 2, blocks[1].Hash(BlockHasher{}))
+	assert.Nil(t, s.Put(other))
+	b, err := s.GetByHeight(2)
+	assert.Nil(t, err)
+	assert.Equal(t, other.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+}
+
+func TestMemoryStoreReads(t *testing.T) {
+	testStorageReads(t, NewMemoryStore())
+}
+
+func TestMemoryStoreRewind(t *testing.T) {
+	testStorageRewind(t, NewMemoryStore())
+}
+
+func TestFileStoreReads(t *testing.T) {
+	s := newFileStore(t, t.TempDir(), FileStoreOpts{SegmentSize: 1})
+	defer s.Close()
+	testStorageReads(t, s)
+}
+
+func TestFileStoreRewind(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	testStorageRewind(t, s)
+	assert.Nil(t, s.Close())
+
+	s = newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	defer s.Close()
+	assert.Len(t, s.locations, 3)
+}
+
+func TestCachedStoreRewind(t *testing.T) {
+	testStorageRewind(t, NewCachedStore(NewMemoryStore(), 8))
+}
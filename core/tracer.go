@@ -0,0 +1,83 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// TraceStep is the state of the VM around a single instruction.
+type TraceStep struct {
+	IP      int
+	Op      Instruction
+	Stack   []*big.Int
+	GasLeft uint64
+	// Writes holds the storage writes made by the instruction. It is only
+	// set after the instruction ran.
+	Writes map[string][]byte
+}
+
+// Tracer is called by VM.Run before and after every instruction it
+// executes. err is the error the instruction failed with, if any.
+type Tracer interface {
+	BeforeStep(step *TraceStep)
+	AfterStep(step *TraceStep, err error)
+}
+
+// JSONTracer writes every step as one JSON object per line.
+type JSONTracer struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w)}
+}
+
+type jsonTraceStep struct {
+	Event   string            `json:"event"`
+	IP      int               `json:"ip"`
+	Op      string            `json:"op"`
+	Stack   []string          `json:"stack"`
+	GasLeft uint64            `json:"gasLeft"`
+	Writes  map[string]string `json:"writes,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+func (t *JSONTracer) BeforeStep(step *TraceStep) {
+	t.write("before", step, nil)
+}
+
+func (t *JSONTracer) AfterStep(step *TraceStep, err error) {
+	t.write("after", step, err)
+}
+
+func (t *JSONTracer) write(event string, step *TraceStep, err error) {
+	s := jsonTraceStep{
+		Event:   event,
+		IP:      step.IP,
+		Op:      step.Op.String(),
+		Stack:   make([]string, len(step.Stack)),
+		GasLeft: step.GasLeft,
+	}
+	for i, v := range step.Stack {
+		s.Stack[i] = fmt.Sprintf("%#x", v)
+	}
+	if len(step.Writes) > 0 {
+		s.Writes = make(map[string]string, len(step.Writes))
+		for k, v := range step.Writes {
+			s.Writes[hex.EncodeToString([]byte(k))] = hex.EncodeToString(v)
+		}
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// a tracer must never make execution fail, so encoding errors are dropped
+	t.enc.Encode(s)
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONTracer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	ctx := &ExecContext{Contract: types.RandomAddress(), State: NewState()}
+
+	// storage[1] = 42
+	vm := NewVM(program(push1(42), push1(1), InstrSStore), ctx, testGasLimit)
+	vm.SetTracer(NewJSONTracer(buf))
+	assert.Nil(t, vm.Run())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 6)
+
+	steps := make([]jsonTraceStep, len(lines))
+	for i, l := range lines {
+		assert.Nil(t, json.Unmarshal([]byte(l), &steps[i]))
+	}
+
+	assert.Equal(t, "before", steps[0].Event)
+	assert.Equal(t, "PUSH1", steps[0].Op)
+	assert.Empty(t, steps[0].Stack)
+	assert.Equal(t, uint64(testGasLimit), steps[0].GasLeft)
+
+	assert.Equal(t, "after", steps[1].Event)
+	assert.Equal(t, []string{"0x2a"}, steps[1].Stack)
+	assert.Equal(t, uint64(testGasLimit-3), steps[1].GasLeft)
+
+	last := steps[5]
+	assert.Equal(t, "SSTORE", last.Op)
+	assert.Equal(t, 4, last.IP)
+	assert.Empty(t, last.Stack)
+	key := hex.EncodeToString(wordBytes(big.NewInt(1)))
+	value := hex.EncodeToString(wordBytes(big.NewInt(42)))
+	assert.Equal(t, map[string]string{key: value}, last.Writes)
+}
+
+func TestJSONTracerError(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	vm := NewVM([]byte{byte(InstrAdd)}, nil, testGasLimit)
+	vm.SetTracer(NewJSONTracer(buf))
+	assert.NotNil(t, vm.Run())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	step := jsonTraceStep{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &step))
+	assert.Contains(t, step.Error, "stack underflow")
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/hitenjain14/go-blockchain/crypto"
@@ -41,6 +43,33 @@ func (tx *Transaction) Verify() error {
 
 }
 
+// Bytes returns the binary encoding of the transaction that block data
+// hashes are computed from: the length prefixed data, the length prefixed
+// sender key and the signature as two 32 byte integers.
+func (tx *Transaction) Bytes() []byte {
+	buf := &bytes.Buffer{}
+
+	binary.Write(buf, binary.LittleEndian, uint32(len(tx.Data)))
+	buf.Write(tx.Data)
+
+	from := []byte{}
+	if tx.From.Key != nil {
+		from = tx.From.ToSlice()
+	}
+	binary.Write(buf, binary.LittleEndian, uint32(len(from)))
+	buf.Write(from)
+
+	r, s := make([]byte, 32), make([]byte, 32)
+	if tx.Signature != nil {
+		tx.Signature.R.FillBytes(r)
+		tx.Signature.S.FillBytes(s)
+	}
+	buf.Write(r)
+	buf.Write(s)
+
+	return buf.Bytes()
+}
+
 func (tx *Transaction) Decode(dec Decoder[*Transaction]) error {
 	return dec.Decode(tx)
 }
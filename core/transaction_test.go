@@ -65,9 +65,11 @@ func TestEncodeDecode(t *testing.T) {
 }
 
 func randomSignedTransaction(t *testing.T) *Transaction {
-	tx := &Transaction{
-		Data: []byte("Hello"),
-	}
+	return signedTransaction(t, []byte("Hello"))
+}
+
+func signedTransaction(t *testing.T, data []byte) *Transaction {
+	tx := NewTransaction(data)
 	privKey := crypto.GeneratePrivateKey()
 	err := tx.Sign(privKey)
 	assert.Nil(t, err)
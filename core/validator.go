@@ -1,9 +1,14 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 )
 
+// ErrUnknownParent is returned for a block whose previous block isn't
+// known to the chain.
+var ErrUnknownParent = errors.New("unknown parent block")
+
 type Validator interface {
 	ValidateBlock(*Block) error
 }
@@ -16,34 +21,39 @@ func NewBlockValidator(bc *Blockchain) *BlockValidator {
 	return &BlockValidator{bc: bc}
 }
 
+// ValidateBlock accepts a block that isn't known yet and builds on a
+// known block, on the canonical chain or on a side branch. A block that
+// fails with ErrUnknownParent has passed every check that doesn't need
+// its parent.
 func (v *BlockValidator) ValidateBlock(b *Block) error {
+	hash := b.Hash(&BlockHasher{})
 
-	if v.bc.HasBlock(b.Height) {
-		return fmt.Errorf("block with %d height already exists in chain with hash %s", b.Height, b.Hash(&BlockHasher{}))
+	if v.bc.HasBlockHash(hash) {
+		return fmt.Errorf("block with %d height already exists in chain with hash %s", b.Height, hash)
 	}
 
-	if b.Height != v.bc.Height()+1 {
-		return fmt.Errorf("block with %d height can't be added to chain with height %d", b.Height, v.bc.Height())
+	// everything that doesn't need the parent is checked first, so that
+	// only blocks that pass are reported as orphans
+	if version := v.bc.Config().Rules(b.Height).Version; b.Version != version {
+		return fmt.Errorf("block with %d height has version %d, expected version %d", b.Height, b.Version, version)
 	}
 
-	prevHeader, err := v.bc.GetHeader(b.Height - 1)
-
-	hash := BlockHasher{}.Hash(prevHeader)
+	if err := b.Verify(); err != nil {
+		return err
+	}
 
-	if hash != b.PrevBlockHash {
-		return fmt.Errorf("block with %d height has invalid previous block hash %s", b.Height, b.PrevBlockHash)
+	if !v.bc.IsValidator(b.Validator) {
+		return fmt.Errorf("block %s is signed by %s, which isn't a validator", hash, b.Validator.Address())
 	}
 
+	prevHeader, err := v.bc.GetHeaderByHash(b.PrevBlockHash)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %s of block %s", ErrUnknownParent, b.PrevBlockHash, hash)
 	}
 
-	if err = b.Verify(); err != nil {
-		return err
+	if b.Height != prevHeader.Height+1 {
+		return fmt.Errorf("block with %d height can't follow block with %d height", b.Height, prevHeader.Height)
 	}
 
 	return nil
 }
-
-// [0,1,2,3]
-// 4 the height
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// CorruptBlockError is returned by VerifyChain for the first stored block
+// that fails a check.
+type CorruptBlockError struct {
+	Height uint32
+	Err    error
+}
+
+func (e *CorruptBlockError) Error() string {
+	return fmt.Sprintf("block with %d height is corrupt: %v", e.Height, e.Err)
+}
+
+func (e *CorruptBlockError) Unwrap() error {
+	return e.Err
+}
+
+// ChainReport is what VerifyChain checked.
+type ChainReport struct {
+	// Blocks is the number of blocks that passed every check.
+	Blocks int
+	// HeaderOnly holds the heights of pruned blocks, of which only the
+	// header and its signature could be checked.
+	HeaderOnly []uint32
+}
+
+// damagedStorage is a Storage that can tell whether blocks past the last
+// one it returns were lost to damage.
+type damagedStorage interface {
+	Damage() error
+}
+
+// signedHeaderStorage is a Storage that keeps the validator and signature
+// of blocks whose transactions were pruned.
+type signedHeaderStorage interface {
+	SignedHeader(height uint32) (*Block, error)
+}
+
+// VerifyChain walks the blocks of s from genesis to the tip and reports
+// the blocks it checked. For every block it recomputes the header hash,
+// checks the link to the previous block and that the block can be found
+// by its hash. Blocks that still have their transactions are also
+// verified with Block.Verify, which checks their signatures and data
+// hash. Of pruned blocks only the signature of the header can be checked,
+// and their heights are reported as HeaderOnly. Damage a store found past
+// its last readable block is reported at the height after it.
+//
+// The genesis block comes from configuration rather than from a
+// validator, so it isn't expected to be signed, and its data hash may
+// commit to a genesis file rather than to its transactions. Such a data
+// hash can only be checked against genesis, or the genesis s keeps when
+// genesis is nil.
+func VerifyChain(s Storage, genesis *Genesis) (*ChainReport, error) {
+	report := &ChainReport{}
+	if genesis == nil {
+		var err error
+		if genesis, err = storedGenesis(s); err != nil {
+			return report, err
+		}
+	}
+
+	var prev *Header
+	for height := uint32(0); ; height++ {
+		header, err := s.GetHeader(height)
+		if errors.Is(err, ErrBlockNotFound) {
+			if d, ok := s.(damagedStorage); ok && d.Damage() != nil {
+				return report, &CorruptBlockError{Height: height, Err: d.Damage()}
+			}
+			return report, nil
+		}
+		if err != nil {
+			return report, &CorruptBlockError{Height: height, Err: err}
+		}
+
+		headerOnly, err := verifyStoredBlock(s, height, header, prev, genesis)
+		if err != nil {
+			return report, &CorruptBlockError{Height: height, Err: err}
+		}
+		if headerOnly {
+			report.HeaderOnly = append(report.HeaderOnly, height)
+		}
+		report.Blocks++
+		prev = header
+	}
+}
+
+// verifyStoredBlock checks the block with height and reports whether it
+// was pruned, so that only its header could be checked.
+func verifyStoredBlock(s Storage, height uint32, header, prev *Header, genesis *Genesis) (bool, error) {
+	if header.Height != height {
+		return false, fmt.Errorf("header has %d height", header.Height)
+	}
+	hash := BlockHasher{}.Hash(header)
+
+	if prev != nil {
+		if prevHash := (BlockHasher{}).Hash(prev); header.PrevBlockHash != prevHash {
+			return false, fmt.Errorf("previous block hash %s doesn't match %s", header.PrevBlockHash, prevHash)
+		}
+	}
+
+	b, err := s.GetByHeight(height)
+	pruned := errors.Is(err, ErrBlockPruned)
+	if err != nil && !pruned {
+		return false, err
+	}
+
+	// the index must map the hash back to this block
+	if !s.Has(hash) {
+		return false, fmt.Errorf("hash %s is not indexed", hash)
+	}
+	byHash, err := s.GetByHash(hash)
+	if pruned {
+		if !errors.Is(err, ErrBlockPruned) {
+			return false, fmt.Errorf("hash %s doesn't lead to the pruned block: %v", hash, err)
+		}
+		return true, verifyPrunedBlock(s, height, hash, genesis)
+	}
+	if err != nil {
+		return false, err
+	}
+	if byHash.Height != height {
+		return false, fmt.Errorf("hash %s leads to block with %d height", hash, byHash.Height)
+	}
+
+	if blockHash := b.Hash(BlockHasher{}); blockHash != hash {
+		return false, fmt.Errorf("block hash %s doesn't match header hash %s", blockHash, hash)
+	}
+
+	if height == 0 {
+		return false, verifyGenesis(b, genesis)
+	}
+	return false, b.Verify()
+}
+
+// verifyPrunedBlock checks the signature kept for the pruned block with
+// height against its header hash. Without its transactions, its data hash
+// can't be checked.
+func verifyPrunedBlock(s Storage, height uint32, hash types.Hash, genesis *Genesis) error {
+	hs, ok := s.(signedHeaderStorage)
+	if !ok {
+		return fmt.Errorf("store keeps no signature of the pruned block")
+	}
+	b, err := hs.SignedHeader(height)
+	if err != nil {
+		return err
+	}
+	if blockHash := b.Hash(BlockHasher{}); blockHash != hash {
+		return fmt.Errorf("signed header hash %s doesn't match header hash %s", blockHash, hash)
+	}
+
+	if height == 0 {
+		if genesis != nil && hash != genesis.Hash() {
+			return fmt.Errorf("block %s isn't genesis block %s", hash, genesis.Hash())
+		}
+		if b.Signature == nil {
+			return nil
+		}
+	}
+	if b.Signature == nil {
+		return fmt.Errorf("block is not signed")
+	}
+	if !b.Signature.Verify(b.Validator, hash.ToSlice()) {
+		return fmt.Errorf("invalid block signature")
+	}
+	return nil
+}
+
+// verifyGenesis checks the transactions, the signature if there is one
+// and the data hash of the genesis block b.
+func verifyGenesis(b *Block, genesis *Genesis) error {
+	hash := b.Hash(BlockHasher{})
+	if genesis != nil {
+		if want := genesis.Hash(); hash != want {
+			return fmt.Errorf("block %s isn't genesis block %s", hash, want)
+		}
+	}
+
+	if b.Signature != nil && !b.Signature.Verify(b.Validator, hash.ToSlice()) {
+		return fmt.Errorf("invalid block signature")
+	}
+	for _, tx := range b.Transactions {
+		if err := tx.Verify(); err != nil {
+			return err
+		}
+	}
+
+	// a genesis block made from a genesis file has no transactions, and a
+	// data hash that is zero or was confirmed against genesis above
+	if len(b.Transactions) == 0 && (b.DataHash.IsZero() || genesis != nil) {
+		return nil
+	}
+	dataHash, err := CalculateDataHash(b.Transactions)
+	if err != nil {
+		return err
+	}
+	if dataHash != b.DataHash {
+		if len(b.Transactions) == 0 {
+			return fmt.Errorf("data hash %s commits neither to the transactions nor to a known genesis", b.DataHash)
+		}
+		return fmt.Errorf("block (%s) has invalid data hash", hash)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func memoryStoreWith(t *testing.T, blocks []*Block) *MemoryStore {
+	s := NewMemoryStore()
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+	return s
+}
+
+func assertCorruptAt(t *testing.T, err error, height uint32) {
+	var corrupt *CorruptBlockError
+	if assert.True(t, errors.As(err, &corrupt)) {
+		assert.Equal(t, height, corrupt.Height)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	report, err := VerifyChain(memoryStoreWith(t, randomChain(t, 4)), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, report.Blocks)
+}
+
+func TestVerifyChainBrokenLinkage(t *testing.T) {
+	blocks := randomChain(t, 3)
+	blocks = append(blocks, randomBlock(t, 3, types.RandomHash()))
+
+	report, err := VerifyChain(memoryStoreWith(t, blocks), nil)
+	assertCorruptAt(t, err, 3)
+	assert.Equal(t, 3, report.Blocks)
+}
+
+func TestVerifyChainTamperedTransaction(t *testing.T) {
+	blocks := randomChain(t, 4)
+	blocks[2].Transactions[0].Data = []byte("tampered")
+
+	_, err := VerifyChain(memoryStoreWith(t, blocks), nil)
+	assertCorruptAt(t, err, 2)
+}
+
+func TestVerifyChainPruned(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1, PruneDepth: 2})
+	blocks := randomChain(t, 5)
+	for _, b := range blocks {
+		assert.Nil(t, s.Put(b))
+	}
+	putSnapshot(t, s, blocks, 4)
+	defer s.Close()
+
+	report, err := VerifyChain(s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, report.Blocks)
+	assert.Equal(t, []uint32{0, 1, 2}, report.HeaderOnly)
+}
+
+// prunedMemoryStore is a MemoryStore with the transactions of the blocks
+// below pruned cut off.
+type prunedMemoryStore struct {
+	*MemoryStore
+	pruned uint32
+}
+
+func (s *prunedMemoryStore) GetByHeight(height uint32) (*Block, error) {
+	if height < s.pruned {
+		return nil, ErrBlockPruned
+	}
+	return s.MemoryStore.GetByHeight(height)
+}
+
+func (s *prunedMemoryStore) GetByHash(hash types.Hash) (*Block, error) {
+	b, err := s.MemoryStore.GetByHash(hash)
+	if err == nil && b.Height < s.pruned {
+		return nil, ErrBlockPruned
+	}
+	return b, err
+}
+
+func (s *prunedMemoryStore) SignedHeader(height uint32) (*Block, error) {
+	b, err := s.MemoryStore.GetByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+	return &Block{Header: b.Header, Validator: b.Validator, Signature: b.Signature}, nil
+}
+
+func TestVerifyChainPrunedSignature(t *testing.T) {
+	blocks := randomChain(t, 4)
+	s := &prunedMemoryStore{MemoryStore: memoryStoreWith(t, blocks), pruned: 3}
+
+	report, err := VerifyChain(s, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{0, 1, 2}, report.HeaderOnly)
+
+	// the signature of a pruned block is still checked
+	blocks[1].Signature = blocks[2].Signature
+	report, err = VerifyChain(s, nil)
+	assertCorruptAt(t, err, 1)
+	assert.Equal(t, 1, report.Blocks)
+	assert.Equal(t, []uint32{0}, report.HeaderOnly)
+}
+
+func TestVerifyChainCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	for _, b := range randomChain(t, 3) {
+		assert.Nil(t, s.Put(b))
+	}
+	assert.Nil(t, s.Close())
+
+	// flip a byte in the middle of the second block
+	path := filepath.Join(dir, "segment-000001.dat")
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	data[len(data)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, data, 0o644))
+
+	s = newFileStore(t, dir, FileStoreOpts{ReadOnly: true})
+	defer s.Close()
+
+	_, err = VerifyChain(s, nil)
+	assertCorruptAt(t, err, 1)
+
+	// the read-only store left the damaged segment alone
+	after, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, data, after)
+}
+
+func TestVerifyChainTruncatedSegment(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	for _, b := range randomChain(t, 6) {
+		assert.Nil(t, s.Put(b))
+	}
+	assert.Nil(t, s.Close())
+
+	// cut the fourth block in half, the blocks after it are intact
+	path := filepath.Join(dir, "segment-000003.dat")
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(path, info.Size()/2))
+
+	s = newFileStore(t, dir, FileStoreOpts{ReadOnly: true})
+	defer s.Close()
+
+	report, err := VerifyChain(s, nil)
+	assertCorruptAt(t, err, 3)
+	assert.Equal(t, 3, report.Blocks)
+}
+
+func TestVerifyChainLostSegment(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir, FileStoreOpts{SegmentSize: 1})
+	for _, b := range randomChain(t, 3) {
+		assert.Nil(t, s.Put(b))
+	}
+	assert.Nil(t, s.Close())
+
+	// the index still has an entry for the last block
+	assert.Nil(t, os.Remove(filepath.Join(dir, "segment-000002.dat")))
+
+	s = newFileStore(t, dir, FileStoreOpts{ReadOnly: true})
+	defer s.Close()
+
+	report, err := VerifyChain(s, nil)
+	assertCorruptAt(t, err, 2)
+	assert.Equal(t, 2, report.Blocks)
+}
+
+func TestVerifyChainTamperedGenesis(t *testing.T) {
+	blocks := randomChain(t, 2)
+	blocks[0].Transactions[0].Data = []byte("tampered")
+
+	_, err := VerifyChain(memoryStoreWith(t, blocks), nil)
+	assertCorruptAt(t, err, 0)
+}
+
+func TestVerifyChainGenesisFile(t *testing.T) {
+	g := &Genesis{Config: &ChainConfig{
+		ChainID:  7,
+		Upgrades: DefaultChainConfig().Upgrades,
+	}}
+	genesis := g.Block()
+	s := memoryStoreWith(t, []*Block{
+		genesis,
+		randomBlock(t, 1, genesis.Hash(BlockHasher{})),
+	})
+
+	report, err := VerifyChain(s, g)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Blocks)
+
+	// without the genesis, its data hash can't be checked
+	_, err = VerifyChain(s, nil)
+	assertCorruptAt(t, err, 0)
+
+	_, err = VerifyChain(s, &Genesis{})
+	assertCorruptAt(t, err, 0)
+
+	// unless the store keeps it
+	assert.Nil(t, s.PutGenesis(g))
+	_, err = VerifyChain(s, nil)
+	assert.Nil(t, err)
+}
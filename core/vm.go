@@ -1,41 +1,596 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
+
+	"github.com/hitenjain14/go-blockchain/types"
 )
 
 type Instruction byte
 
+// Opcode byte values are part of the bytecode format and must never be
+// reused or renumbered.
+//
+// The stack holds unsigned 256-bit words. Binary operations pop the top
+// of the stack as the right operand and the item below it as the left
+// operand, so {PUSH1 a, PUSH1 b, SUB} leaves a-b. Arithmetic wraps modulo
+// 2^256, division and modulo by zero yield 0 and comparisons push 1 or 0.
+const (
+	// 0x0a was the push of the byte stack VM, whose operand preceded the
+	// opcode. It is retired and must not be reused.
+
+	InstrAdd  Instruction = 0x0b // a + b
+	InstrSub  Instruction = 0x0c // a - b
+	InstrMul  Instruction = 0x0d // a * b
+	InstrDiv  Instruction = 0x0e // a / b, 0 if b == 0
+	InstrMod  Instruction = 0x0f // a % b, 0 if b == 0
+	InstrLt   Instruction = 0x10 // a < b
+	InstrGt   Instruction = 0x11 // a > b
+	InstrEq   Instruction = 0x12 // a == b
+	InstrAnd  Instruction = 0x13 // a & b
+	InstrOr   Instruction = 0x14 // a | b
+	InstrXor  Instruction = 0x15 // a ^ b
+	InstrNot  Instruction = 0x16 // ^a
+	InstrDup  Instruction = 0x17 // duplicate the top item
+	InstrSwap Instruction = 0x18 // swap the two top items
+	InstrPop  Instruction = 0x19 // discard the top item
+
+	// Jumps pop their destination from the top of the stack; InstrJumpI
+	// then pops the condition and only jumps when it is non-zero. A
+	// destination must be the offset of an InstrJumpDest.
+	InstrJump     Instruction = 0x1a // jump to dest
+	InstrJumpI    Instruction = 0x1b // jump to dest if cond != 0
+	InstrJumpDest Instruction = 0x1c // marks a valid jump destination
+
+	// Storage instructions read and write the storage of the contract the
+	// VM runs for. Writes only become visible in the State once Run
+	// succeeds.
+	InstrSLoad  Instruction = 0x1d // push storage[key], 0 if unset
+	InstrSStore Instruction = 0x1e // storage[key] = value, key on top
+
+	// Environment instructions push a value of the ExecContext.
+	InstrHeight    Instruction = 0x1f // block height
+	InstrTimestamp Instruction = 0x20 // block timestamp
+	InstrSender    Instruction = 0x21 // sender address
+	InstrTxHash    Instruction = 0x22 // transaction hash
+
+	// InstrPush1 to InstrPush32 push the 1 to 32 bytes following the
+	// opcode as a big-endian word.
+	InstrPush1  Instruction = 0x60
+	InstrPush32 Instruction = 0x7f
+)
+
 const (
-	InstrPush Instruction = 0x0a
-	InstrAdd  Instruction = 0x0b
+	stackSize = 1024
+	wordBits  = 256
 )
 
+// ErrOutOfGas is returned by Run when the program needs more gas than
+// the VM was given.
+var ErrOutOfGas = errors.New("out of gas")
+
+var wordMod = new(big.Int).Lsh(big.NewInt(1), wordBits)
+var wordMax = new(big.Int).Sub(wordMod, big.NewInt(1))
+
+// gasTable holds the gas charged for each instruction before it executes.
+var gasTable = map[Instruction]uint64{
+	InstrAdd:  3,
+	InstrSub:  3,
+	InstrMul:  5,
+	InstrDiv:  5,
+	InstrMod:  5,
+	InstrLt:   3,
+	InstrGt:   3,
+	InstrEq:   3,
+	InstrAnd:  3,
+	InstrOr:   3,
+	InstrXor:  3,
+	InstrNot:  3,
+	InstrDup:  2,
+	InstrSwap: 2,
+	InstrPop:  1,
+
+	InstrJump:     8,
+	InstrJumpI:    10,
+	InstrJumpDest: 1,
+
+	InstrSLoad:  50,
+	InstrSStore: 100,
+
+	InstrHeight:    2,
+	InstrTimestamp: 2,
+	InstrSender:    2,
+	InstrTxHash:    2,
+}
+
+var instrNames = map[Instruction]string{
+	InstrAdd:       "ADD",
+	InstrSub:       "SUB",
+	InstrMul:       "MUL",
+	InstrDiv:       "DIV",
+	InstrMod:       "MOD",
+	InstrLt:        "LT",
+	InstrGt:        "GT",
+	InstrEq:        "EQ",
+	InstrAnd:       "AND",
+	InstrOr:        "OR",
+	InstrXor:       "XOR",
+	InstrNot:       "NOT",
+	InstrDup:       "DUP",
+	InstrSwap:      "SWAP",
+	InstrPop:       "POP",
+	InstrJump:      "JUMP",
+	InstrJumpI:     "JUMPI",
+	InstrJumpDest:  "JUMPDEST",
+	InstrSLoad:     "SLOAD",
+	InstrSStore:    "SSTORE",
+	InstrHeight:    "HEIGHT",
+	InstrTimestamp: "TIMESTAMP",
+	InstrSender:    "SENDER",
+	InstrTxHash:    "TXHASH",
+}
+
+func init() {
+	for i := InstrPush1; i <= InstrPush32; i++ {
+		gasTable[i] = 3
+		instrNames[i] = fmt.Sprintf("PUSH%d", i.PushSize())
+	}
+}
+
+func (i Instruction) String() string {
+	if name, ok := instrNames[i]; ok {
+		return name
+	}
+	return fmt.Sprintf("INVALID(0x%02x)", byte(i))
+}
+
+// IsValid reports whether i is a known instruction.
+func (i Instruction) IsValid() bool {
+	_, ok := instrNames[i]
+	return ok
+}
+
+// IsPush reports whether i is one of InstrPush1 to InstrPush32.
+func (i Instruction) IsPush() bool {
+	return i >= InstrPush1 && i <= InstrPush32
+}
+
+// PushSize returns the number of immediate bytes of a push instruction
+// and 0 for any other instruction.
+func (i Instruction) PushSize() int {
+	if !i.IsPush() {
+		return 0
+	}
+	return int(i-InstrPush1) + 1
+}
+
+// PushInstruction returns the push instruction carrying n immediate bytes.
+func PushInstruction(n int) (Instruction, error) {
+	if n < 1 || n > 32 {
+		return 0, fmt.Errorf("push size %d out of range 1-32", n)
+	}
+	return InstrPush1 + Instruction(n-1), nil
+}
+
+// ParseInstruction returns the instruction with the given mnemonic.
+func ParseInstruction(name string) (Instruction, bool) {
+	for instr, n := range instrNames {
+		if n == name {
+			return instr, true
+		}
+	}
+	return 0, false
+}
+
+// ExecContext is the block and transaction environment a program runs
+// in. Storage instructions operate on the storage of Contract in State.
+type ExecContext struct {
+	Height    uint32
+	Timestamp int64
+	Sender    types.Address
+	TxHash    types.Hash
+	Contract  types.Address
+	State     *State
+}
+
+// NewExecContext returns the context for running tx in the block with
+// header h. A transaction's program runs against the storage of its
+// sender.
+func NewExecContext(h *Header, tx *Transaction, state *State) *ExecContext {
+	sender := tx.From.Address()
+
+	return &ExecContext{
+		Height:    h.Height,
+		Timestamp: h.Timestamp,
+		Sender:    sender,
+		TxHash:    tx.Hash(TxHasher{}),
+		Contract:  sender,
+		State:     state,
+	}
+}
+
 type VM struct {
-	data  []byte
-	ip    int // instruction pointer
-	stack []byte
-	sp    int // stack pointer
+	data     []byte
+	ip       int // instruction pointer
+	stack    []*big.Int
+	sp       int // stack pointer
+	gasLimit uint64
+	gasUsed  uint64
+
+	jumpDests map[int]bool
+
+	ctx    *ExecContext
+	writes map[string][]byte // staged until Run succeeds
+
+	tracer     Tracer
+	stepWrites map[string][]byte
 }
 
-func NewVM(data []byte) *VM {
+// NewVM returns a VM that runs data in ctx. A nil ctx runs the program
+// without an environment or storage.
+func NewVM(data []byte, ctx *ExecContext, gasLimit uint64) *VM {
+	if ctx == nil {
+		ctx = &ExecContext{}
+	}
+
 	return &VM{
-		data:  data,
-		ip:    0,
-		stack: make([]byte, 1024),
-		sp:    -1,
+		data:     data,
+		ip:       0,
+		stack:    make([]*big.Int, stackSize),
+		sp:       -1,
+		gasLimit: gasLimit,
+		ctx:      ctx,
+		writes:   make(map[string][]byte),
 	}
 }
 
+// SetTracer makes Run report every instruction it executes to t.
+func (vm *VM) SetTracer(t Tracer) {
+	vm.tracer = t
+}
+
 func (vm *VM) Run() error {
 
-	for {
-		instr := vm.data[vm.ip]
-		fmt.Println(instr)
-		vm.ip++
-		if vm.ip >= len(vm.data) {
-			break
+	if err := vm.analyse(); err != nil {
+		return err
+	}
+
+	for vm.ip < len(vm.data) {
+		ip := vm.ip
+		instr, operand, size := DecodeInstruction(vm.data, vm.ip)
+
+		if vm.tracer != nil {
+			vm.stepWrites = make(map[string][]byte)
+			vm.tracer.BeforeStep(vm.traceStep(ip, instr, nil))
+		}
+
+		err := vm.step(instr, operand, size)
+
+		if vm.tracer != nil {
+			vm.tracer.AfterStep(vm.traceStep(ip, instr, vm.stepWrites), err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if vm.ctx.State != nil {
+		vm.ctx.State.Commit(vm.ctx.Contract, vm.writes)
+	}
+	return nil
+}
+
+func (vm *VM) step(instr Instruction, operand []byte, size int) error {
+	if err := vm.useGas(instr); err != nil {
+		return err
+	}
+
+	switch {
+	case instr.IsPush():
+		if err := vm.push(new(big.Int).SetBytes(operand)); err != nil {
+			return err
+		}
+	case instr == InstrJump || instr == InstrJumpI:
+		return vm.jump(instr)
+	default:
+		if err := vm.exec(instr); err != nil {
+			return err
 		}
 	}
+
+	vm.ip += size
 	return nil
 }
+
+func (vm *VM) traceStep(ip int, instr Instruction, writes map[string][]byte) *TraceStep {
+	return &TraceStep{
+		IP:      ip,
+		Op:      instr,
+		Stack:   vm.Stack(),
+		GasLeft: vm.gasLimit - vm.gasUsed,
+		Writes:  writes,
+	}
+}
+
+// DecodeInstruction returns the instruction starting at pos, its push
+// immediate and its size in bytes. The immediate of a push cut off by the
+// end of code is returned truncated.
+func DecodeInstruction(code []byte, pos int) (instr Instruction, operand []byte, size int) {
+	instr = Instruction(code[pos])
+	n := instr.PushSize()
+	if n == 0 {
+		return instr, nil, 1
+	}
+
+	end := pos + 1 + n
+	if end > len(code) {
+		end = len(code)
+	}
+	return instr, code[pos+1 : end], 1 + n
+}
+
+// analyse collects the jump destinations of the program and rejects it
+// before it runs when a push is truncated or a jump whose destination is
+// pushed right before it does not land on an InstrJumpDest. Destinations
+// computed at run time are checked when the jump executes.
+func (vm *VM) analyse() error {
+	vm.jumpDests = make(map[int]bool)
+
+	type staticJump struct {
+		pos  int
+		dest *big.Int
+	}
+	jumps := []staticJump{}
+
+	var prevPush *big.Int
+	for pos := 0; pos < len(vm.data); {
+		instr, operand, size := DecodeInstruction(vm.data, pos)
+		switch instr {
+		case InstrJumpDest:
+			vm.jumpDests[pos] = true
+		case InstrJump, InstrJumpI:
+			if prevPush != nil {
+				jumps = append(jumps, staticJump{pos: pos, dest: prevPush})
+			}
+		}
+
+		prevPush = nil
+		if instr.IsPush() {
+			if len(operand) != instr.PushSize() {
+				return fmt.Errorf("%s at %d is truncated", instr, pos)
+			}
+			prevPush = new(big.Int).SetBytes(operand)
+		}
+		pos += size
+	}
+
+	for _, j := range jumps {
+		if !vm.isJumpDest(j.dest) {
+			return fmt.Errorf("jump at %d has invalid destination %s", j.pos, j.dest)
+		}
+	}
+	return nil
+}
+
+func (vm *VM) isJumpDest(dest *big.Int) bool {
+	return dest.IsInt64() && vm.jumpDests[int(dest.Int64())]
+}
+
+func (vm *VM) jump(instr Instruction) error {
+	dest, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if instr == InstrJumpI {
+		cond, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if cond.Sign() == 0 {
+			vm.ip++
+			return nil
+		}
+	}
+	if !vm.isJumpDest(dest) {
+		return fmt.Errorf("jump at %d has invalid destination %s", vm.ip, dest)
+	}
+	vm.ip = int(dest.Int64())
+	return nil
+}
+
+// GasUsed returns the gas consumed so far. After ErrOutOfGas the whole
+// limit counts as used.
+func (vm *VM) GasUsed() uint64 {
+	return vm.gasUsed
+}
+
+func (vm *VM) useGas(instr Instruction) error {
+	cost, ok := gasTable[instr]
+	if !ok {
+		return fmt.Errorf("invalid instruction %x at %d", byte(instr), vm.ip)
+	}
+	if vm.gasLimit-vm.gasUsed < cost {
+		vm.gasUsed = vm.gasLimit
+		return fmt.Errorf("%w: instruction %x at %d", ErrOutOfGas, byte(instr), vm.ip)
+	}
+	vm.gasUsed += cost
+	return nil
+}
+
+func (vm *VM) exec(instr Instruction) error {
+	switch instr {
+	case InstrAdd, InstrSub, InstrMul, InstrDiv, InstrMod,
+		InstrLt, InstrGt, InstrEq, InstrAnd, InstrOr, InstrXor:
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.push(binaryOp(instr, a, b))
+	case InstrNot:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		return vm.push(new(big.Int).Xor(a, wordMax))
+	case InstrDup:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if err := vm.push(a); err != nil {
+			return err
+		}
+		return vm.push(new(big.Int).Set(a))
+	case InstrSwap:
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if err := vm.push(b); err != nil {
+			return err
+		}
+		return vm.push(a)
+	case InstrPop:
+		_, err := vm.pop()
+		return err
+	case InstrJumpDest:
+		return nil
+	case InstrSLoad:
+		key, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		v, err := vm.load(wordBytes(key))
+		if err != nil {
+			return err
+		}
+		return vm.push(v)
+	case InstrSStore:
+		key, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if vm.ctx.State == nil {
+			return fmt.Errorf("sstore at %d: vm has no contract storage", vm.ip)
+		}
+		k := string(wordBytes(key))
+		vm.writes[k] = wordBytes(v)
+		if vm.stepWrites != nil {
+			vm.stepWrites[k] = wordBytes(v)
+		}
+		return nil
+	case InstrHeight:
+		return vm.push(new(big.Int).SetUint64(uint64(vm.ctx.Height)))
+	case InstrTimestamp:
+		return vm.push(new(big.Int).SetUint64(uint64(vm.ctx.Timestamp)))
+	case InstrSender:
+		return vm.push(new(big.Int).SetBytes(vm.ctx.Sender.ToSlice()))
+	case InstrTxHash:
+		return vm.push(new(big.Int).SetBytes(vm.ctx.TxHash.ToSlice()))
+	default:
+		return fmt.Errorf("invalid instruction %x at %d", byte(instr), vm.ip)
+	}
+}
+
+func binaryOp(instr Instruction, a, b *big.Int) *big.Int {
+	r := new(big.Int)
+	switch instr {
+	case InstrAdd:
+		r.Add(a, b)
+	case InstrSub:
+		r.Sub(a, b)
+	case InstrMul:
+		r.Mul(a, b)
+	case InstrDiv:
+		if b.Sign() != 0 {
+			r.Div(a, b)
+		}
+	case InstrMod:
+		if b.Sign() != 0 {
+			r.Mod(a, b)
+		}
+	case InstrLt:
+		r.SetInt64(boolWord(a.Cmp(b) < 0))
+	case InstrGt:
+		r.SetInt64(boolWord(a.Cmp(b) > 0))
+	case InstrEq:
+		r.SetInt64(boolWord(a.Cmp(b) == 0))
+	case InstrAnd:
+		r.And(a, b)
+	case InstrOr:
+		r.Or(a, b)
+	case InstrXor:
+		r.Xor(a, b)
+	default:
+		panic(fmt.Sprintf("binaryOp: %x is not a binary instruction", byte(instr)))
+	}
+	// Mod is euclidean, so this also wraps negative results of Sub
+	return r.Mod(r, wordMod)
+}
+
+func boolWord(v bool) int64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// wordBytes returns v as a 32 byte big-endian slice.
+func wordBytes(v *big.Int) []byte {
+	b := make([]byte, wordBits/8)
+	return v.FillBytes(b)
+}
+
+func (vm *VM) load(key []byte) (*big.Int, error) {
+	if vm.ctx.State == nil {
+		return nil, fmt.Errorf("sload at %d: vm has no contract storage", vm.ip)
+	}
+
+	v, ok := vm.writes[string(key)]
+	if !ok {
+		v, _ = vm.ctx.State.Get(vm.ctx.Contract, key)
+	}
+	return new(big.Int).SetBytes(v), nil
+}
+
+// Stack returns a copy of the stack contents, bottom first.
+func (vm *VM) Stack() []*big.Int {
+	s := make([]*big.Int, vm.sp+1)
+	for i := range s {
+		s[i] = new(big.Int).Set(vm.stack[i])
+	}
+	return s
+}
+
+func (vm *VM) push(v *big.Int) error {
+	if vm.sp+1 >= len(vm.stack) {
+		return fmt.Errorf("stack overflow at %d", vm.ip)
+	}
+	vm.sp++
+	vm.stack[vm.sp] = v
+	return nil
+}
+
+func (vm *VM) pop() (*big.Int, error) {
+	if vm.sp < 0 {
+		return nil, fmt.Errorf("stack underflow at %d", vm.ip)
+	}
+	v := vm.stack[vm.sp]
+	vm.stack[vm.sp] = nil
+	vm.sp--
+	return v, nil
+}
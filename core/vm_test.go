@@ -1,16 +1,252 @@
 package core
 
 import (
+	"fmt"
+	"math/big"
 	"testing"
 
+	"github.com/hitenjain14/go-blockchain/types"
 	"github.com/stretchr/testify/assert"
 )
 
+const testGasLimit = 100000
+
+// program concatenates instructions and push immediates into bytecode.
+func program(parts ...any) []byte {
+	code := []byte{}
+	for _, p := range parts {
+		switch v := p.(type) {
+		case Instruction:
+			code = append(code, byte(v))
+		case byte:
+			code = append(code, v)
+		case []byte:
+			code = append(code, v...)
+		default:
+			panic(fmt.Sprintf("program: unexpected part %T", p))
+		}
+	}
+	return code
+}
+
+func push1(v byte) []byte {
+	return []byte{byte(InstrPush1), v}
+}
+
+func stackString(vm *VM) string {
+	return fmt.Sprint(vm.Stack())
+}
+
 func TestVM(t *testing.T) {
 
-	data := []byte{0x01, 0x0a, 0x02, 0x0a, 0x0b}
+	data := program(push1(1), push1(2), InstrAdd)
 
-	vm := NewVM(data)
+	vm := NewVM(data, nil, testGasLimit)
 	err := vm.Run()
 	assert.Nil(t, err)
+	assert.Equal(t, "[3]", stackString(vm))
+}
+
+func TestVMStackUnderflow(t *testing.T) {
+	vm := NewVM(program(push1(1), InstrAdd), nil, testGasLimit)
+	assert.NotNil(t, vm.Run())
+}
+
+func TestVMStackOverflow(t *testing.T) {
+	data := []byte{}
+	for i := 0; i <= stackSize; i++ {
+		data = append(data, push1(1)...)
+	}
+	vm := NewVM(data, nil, testGasLimit)
+	assert.NotNil(t, vm.Run())
+}
+
+func TestVMPush(t *testing.T) {
+	word := make([]byte, 32)
+	word[0] = 0x80
+	word[31] = 0x01
+
+	data := program(InstrPush1+1, []byte{0x01, 0x00}, InstrPush32, word)
+
+	vm := NewVM(data, nil, testGasLimit)
+	assert.Nil(t, vm.Run())
+
+	stack := vm.Stack()
+	assert.Len(t, stack, 2)
+	assert.Equal(t, int64(256), stack[0].Int64())
+	assert.Equal(t, word, wordBytes(stack[1]))
+}
+
+func TestVMPushTruncated(t *testing.T) {
+	vm := NewVM(program(InstrPush1+3, []byte{0x01, 0x02}), nil, testGasLimit)
+	assert.NotNil(t, vm.Run())
+	assert.Equal(t, uint64(0), vm.GasUsed())
+}
+
+func TestVMInstructions(t *testing.T) {
+	maxWord := "115792089237316195423570985008687907853269984665640564039457584007913129639935"
+
+	tests := []struct {
+		name string
+		code []byte
+		want string
+	}{
+		{"sub", program(push1(5), push1(3), InstrSub), "[2]"},
+		{"sub wraps", program(push1(0), push1(1), InstrSub), "[" + maxWord + "]"},
+		{"add wraps", program(push1(0), push1(1), InstrSub, push1(2), InstrAdd), "[1]"},
+		{"mul", program(push1(4), push1(3), InstrMul), "[12]"},
+		{"div", program(push1(7), push1(2), InstrDiv), "[3]"},
+		{"div by zero", program(push1(7), push1(0), InstrDiv), "[0]"},
+		{"mod", program(push1(7), push1(2), InstrMod), "[1]"},
+		{"mod by zero", program(push1(7), push1(0), InstrMod), "[0]"},
+		{"lt", program(push1(1), push1(2), InstrLt), "[1]"},
+		{"gt", program(push1(1), push1(2), InstrGt), "[0]"},
+		{"eq", program(push1(2), push1(2), InstrEq), "[1]"},
+		{"and", program(push1(6), push1(3), InstrAnd), "[2]"},
+		{"or", program(push1(6), push1(3), InstrOr), "[7]"},
+		{"xor", program(push1(6), push1(3), InstrXor), "[5]"},
+		{"not", program(push1(0), InstrNot), "[" + maxWord + "]"},
+		{"dup", program(push1(4), InstrDup), "[4 4]"},
+		{"swap", program(push1(1), push1(2), InstrSwap), "[2 1]"},
+		{"pop", program(push1(1), push1(2), InstrPop), "[1]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(tt.code, nil, testGasLimit)
+			assert.Nil(t, vm.Run())
+			assert.Equal(t, tt.want, stackString(vm))
+		})
+	}
+}
+
+func TestVMInvalidInstruction(t *testing.T) {
+	vm := NewVM([]byte{0xff}, nil, testGasLimit)
+	assert.NotNil(t, vm.Run())
+}
+
+func TestVMGas(t *testing.T) {
+	data := program(push1(1), push1(2), InstrAdd)
+
+	vm := NewVM(data, nil, 9)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, uint64(9), vm.GasUsed())
+
+	vm = NewVM(data, nil, 8)
+	err := vm.Run()
+	assert.ErrorIs(t, err, ErrOutOfGas)
+	assert.Equal(t, uint64(8), vm.GasUsed())
+}
+
+func TestVMJump(t *testing.T) {
+	// 0: PUSH1 4, 2: JUMP, 3: invalid, 4: JUMPDEST, 5: PUSH1 7
+	data := program(push1(4), InstrJump, byte(0xff), InstrJumpDest, push1(7))
+
+	vm := NewVM(data, nil, testGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, "[7]", stackString(vm))
+}
+
+func TestVMJumpI(t *testing.T) {
+	// counts 3 down to 0:
+	//  0: PUSH1 3
+	//  2: JUMPDEST
+	//  3: PUSH1 1, 5: SUB, 6: DUP, 7: PUSH1 2, 9: JUMPI
+	data := program(
+		push1(3),
+		InstrJumpDest,
+		push1(1), InstrSub, InstrDup, push1(2), InstrJumpI,
+	)
+
+	vm := NewVM(data, nil, testGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, "[0]", stackString(vm))
+}
+
+func TestVMJumpInvalidDestination(t *testing.T) {
+	// jumping into the immediate of a push is rejected before execution
+	data := program(push1(byte(InstrJumpDest)), push1(1), InstrJump)
+
+	vm := NewVM(data, nil, testGasLimit)
+	assert.NotNil(t, vm.Run())
+	assert.Equal(t, uint64(0), vm.GasUsed())
+
+	// destinations computed at run time are checked when jumping
+	data = program(push1(0), push1(1), InstrAdd, InstrJump)
+	vm = NewVM(data, nil, testGasLimit)
+	assert.NotNil(t, vm.Run())
+}
+
+func TestVMInfiniteLoopRunsOutOfGas(t *testing.T) {
+	data := program(InstrJumpDest, push1(0), InstrJump)
+
+	vm := NewVM(data, nil, 1000)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+}
+
+func TestVMStorage(t *testing.T) {
+	state := NewState()
+	contract := types.RandomAddress()
+	key := wordBytes(big.NewInt(1))
+
+	// storage[1] = 42
+	vm := NewVM(program(push1(42), push1(1), InstrSStore), &ExecContext{Contract: contract, State: state}, testGasLimit)
+	assert.Nil(t, vm.Run())
+
+	v, ok := state.Get(contract, key)
+	assert.True(t, ok)
+	assert.Equal(t, wordBytes(big.NewInt(42)), v)
+
+	// push storage[1] + 1
+	vm = NewVM(program(push1(1), InstrSLoad, push1(1), InstrAdd), &ExecContext{Contract: contract, State: state}, testGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, "[43]", stackString(vm))
+
+	// other contracts don't see it
+	vm = NewVM(program(push1(1), InstrSLoad), &ExecContext{Contract: types.RandomAddress(), State: state}, testGasLimit)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, "[0]", stackString(vm))
+}
+
+func TestVMStorageNotCommittedOnFailure(t *testing.T) {
+	state := NewState()
+	contract := types.RandomAddress()
+
+	// storage[1] = 42, then underflow
+	vm := NewVM(program(push1(42), push1(1), InstrSStore, InstrAdd), &ExecContext{Contract: contract, State: state}, testGasLimit)
+	assert.NotNil(t, vm.Run())
+
+	_, ok := state.Get(contract, wordBytes(big.NewInt(1)))
+	assert.False(t, ok)
+}
+
+func TestVMEnvironment(t *testing.T) {
+	ctx := &ExecContext{
+		Height:    0x01020304,
+		Timestamp: 0x05,
+		Sender:    types.RandomAddress(),
+		TxHash:    types.RandomHash(),
+	}
+
+	vm := NewVM(program(InstrHeight, InstrTimestamp, InstrSender, InstrTxHash), ctx, testGasLimit)
+	assert.Nil(t, vm.Run())
+
+	stack := vm.Stack()
+	assert.Len(t, stack, 4)
+	assert.Equal(t, int64(0x01020304), stack[0].Int64())
+	assert.Equal(t, int64(0x05), stack[1].Int64())
+	assert.Equal(t, ctx.Sender.ToSlice(), stack[2].FillBytes(make([]byte, 20)))
+	assert.Equal(t, ctx.TxHash.ToSlice(), wordBytes(stack[3]))
+}
+
+func TestNewExecContext(t *testing.T) {
+	tx := randomSignedTransaction(t)
+	header := &Header{Height: 7, Timestamp: 42}
+
+	ctx := NewExecContext(header, tx, NewState())
+	assert.Equal(t, uint32(7), ctx.Height)
+	assert.Equal(t, int64(42), ctx.Timestamp)
+	assert.Equal(t, tx.From.Address(), ctx.Sender)
+	assert.Equal(t, tx.From.Address(), ctx.Contract)
+	assert.Equal(t, tx.Hash(TxHasher{}), ctx.TxHash)
 }
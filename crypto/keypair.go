@@ -5,6 +5,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"fmt"
 	"math/big"
 
 	"github.com/hitenjain14/go-blockchain/types"
@@ -60,3 +61,35 @@ func (sig *Signature) Verify(pub PublicKey, data []byte) bool {
 	return ecdsa.Verify(pub.Key, data, sig.R, sig.S)
 
 }
+
+// GobEncode encodes the key as a marshalled curve point, since gob can't
+// encode the elliptic.Curve held by ecdsa.PublicKey.
+func (k PublicKey) GobEncode() ([]byte, error) {
+	if k.Key == nil {
+		return []byte{}, nil
+	}
+	return k.ToSlice(), nil
+}
+
+func (k *PublicKey) GobDecode(b []byte) error {
+	if len(b) == 0 {
+		k.Key = nil
+		return nil
+	}
+
+	key, err := PublicKeyFromBytes(b)
+	if err != nil {
+		return err
+	}
+	*k = key
+	return nil
+}
+
+// PublicKeyFromBytes decodes a key in the encoding returned by ToSlice.
+func PublicKeyFromBytes(b []byte) (PublicKey, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), b)
+	if x == nil {
+		return PublicKey{}, fmt.Errorf("invalid public key encoding")
+	}
+	return PublicKey{Key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+}
@@ -1,6 +1,8 @@
 package crypto
 
 import (
+	"bytes"
+	"encoding/gob"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -45,3 +47,15 @@ func TestSignFail(t *testing.T) {
 	// fmt.Printf("%+v", sig)
 
 }
+
+func TestPublicKeyGob(t *testing.T) {
+	pubKey := GeneratePrivateKey().PublicKey()
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(pubKey))
+
+	decoded := PublicKey{}
+	assert.Nil(t, gob.NewDecoder(buf).Decode(&decoded))
+	assert.Equal(t, pubKey.ToSlice(), decoded.ToSlice())
+	assert.Equal(t, pubKey.Address(), decoded.Address())
+}
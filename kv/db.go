@@ -0,0 +1,413 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultMaxFileSize = 64 << 20
+
+	logPattern = "wal-%06d.log"
+
+	// every batch is written as a record: a 4 byte big-endian payload
+	// length, the crc32 of the payload and the encoded batch
+	recordHeaderSize = 8
+
+	// compactBatchSize is the payload size at which Compact starts a new
+	// record.
+	compactBatchSize = 1 << 20
+)
+
+type Options struct {
+	// MaxFileSize is the size in bytes after which a new log file is
+	// started.
+	MaxFileSize int64
+	// NoSync skips the fsync after every batch. A crash may then lose the
+	// latest batches, but never applies one partially.
+	NoSync bool
+}
+
+type valueLocation struct {
+	file   uint32
+	offset int64
+	length uint32
+}
+
+// DB is a Store that appends every batch to a write-ahead log, which is
+// also where values are read from. Only the keys and the locations of
+// their values are kept in memory. On open the log is replayed, and a
+// torn batch at its end, left by a crash, is cut off.
+type DB struct {
+	lock sync.RWMutex
+	dir  string
+	opts Options
+
+	files      map[uint32]*os.File
+	activeID   uint32
+	activeSize int64
+
+	keys map[string]valueLocation
+}
+
+// Open opens the database in dir, creating it when it doesn't exist.
+func Open(dir string, opts Options) (*DB, error) {
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = defaultMaxFileSize
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	db := &DB{
+		dir:   dir,
+		opts:  opts,
+		files: make(map[uint32]*os.File),
+		keys:  make(map[string]valueLocation),
+	}
+
+	if err := db.open(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *DB) Get(key []byte) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	loc, ok := db.keys[string(key)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %x", ErrNotFound, key)
+	}
+	return db.read(loc)
+}
+
+func (db *DB) Has(key []byte) bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	_, ok := db.keys[string(key)]
+	return ok
+}
+
+func (db *DB) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	db.lock.RLock()
+	keys := []string{}
+	for key := range db.keys {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	db.lock.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := db.Get([]byte(key))
+		if err != nil {
+			// deleted since the keys were collected
+			continue
+		}
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) Write(b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.write(b)
+}
+
+// write appends b to the log and applies it. The caller must hold the
+// lock.
+func (db *DB) write(b *Batch) error {
+	payload, offsets := b.encode()
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	record = append(record, payload...)
+
+	size := int64(len(record))
+	if db.activeSize > 0 && db.activeSize+size > db.opts.MaxFileSize {
+		if err := db.rollFile(); err != nil {
+			return err
+		}
+	}
+
+	f := db.files[db.activeID]
+	if _, err := f.WriteAt(record, db.activeSize); err != nil {
+		// don't leave a partial record in front of the next one
+		f.Truncate(db.activeSize)
+		return err
+	}
+	if !db.opts.NoSync {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	db.apply(b, db.activeID, db.activeSize+recordHeaderSize, offsets)
+	db.activeSize += size
+	return nil
+}
+
+// apply updates the key locations for batch b whose payload starts at
+// offset of file.
+func (db *DB) apply(b *Batch, file uint32, offset int64, valueOffsets []int) {
+	for i, o := range b.ops {
+		switch o.kind {
+		case opPut:
+			db.keys[string(o.key)] = valueLocation{
+				file:   file,
+				offset: offset + int64(valueOffsets[i]),
+				length: uint32(len(o.value)),
+			}
+		case opDelete:
+			delete(db.keys, string(o.key))
+		}
+	}
+}
+
+func (db *DB) read(loc valueLocation) ([]byte, error) {
+	value := make([]byte, loc.length)
+	if _, err := db.files[loc.file].ReadAt(value, loc.offset); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Compact rewrites the live values into new log files and removes the old
+// ones, reclaiming the space of overwritten and deleted keys. A crash
+// while compacting leaves both the old and the new files, which replay to
+// the same data.
+func (db *DB) Compact() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	old := []uint32{}
+	for id := range db.files {
+		old = append(old, id)
+	}
+	sort.Slice(old, func(i, j int) bool { return old[i] < old[j] })
+
+	if err := db.rollFile(); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(db.keys))
+	for key := range db.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b, size := NewBatch(), 0
+	for _, key := range keys {
+		value, err := db.read(db.keys[key])
+		if err != nil {
+			return err
+		}
+		b.Put([]byte(key), value)
+		size += len(key) + len(value)
+
+		if size >= compactBatchSize {
+			if err := db.write(b); err != nil {
+				return err
+			}
+			b, size = NewBatch(), 0
+		}
+	}
+	if b.Len() > 0 {
+		if err := db.write(b); err != nil {
+			return err
+		}
+	}
+	if err := db.files[db.activeID].Sync(); err != nil {
+		return err
+	}
+
+	for _, id := range old {
+		db.files[id].Close()
+		delete(db.files, id)
+		if err := os.Remove(db.filePath(id)); err != nil {
+			return err
+		}
+	}
+	return syncDir(db.dir)
+}
+
+// Close syncs and closes the log files.
+func (db *DB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	var firstErr error
+	if f, ok := db.files[db.activeID]; ok {
+		firstErr = f.Sync()
+	}
+	for id, f := range db.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(db.files, id)
+	}
+	return firstErr
+}
+
+func (db *DB) rollFile() error {
+	if f, ok := db.files[db.activeID]; ok {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	id := db.activeID + 1
+	f, err := os.OpenFile(db.filePath(id), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	db.files[id] = f
+	db.activeID = id
+	db.activeSize = 0
+	return syncDir(db.dir)
+}
+
+func (db *DB) filePath(id uint32) string {
+	return filepath.Join(db.dir, fmt.Sprintf(logPattern, id))
+}
+
+// open replays the log files in order.
+func (db *DB) open() error {
+	ids, err := db.fileIDs()
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		f, err := os.OpenFile(db.filePath(0), os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		db.files[0] = f
+		return syncDir(db.dir)
+	}
+
+	for i, id := range ids {
+		f, err := os.OpenFile(db.filePath(id), os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		db.files[id] = f
+
+		size, err := db.replay(id, f, i == len(ids)-1)
+		if err != nil {
+			return err
+		}
+		db.activeID = id
+		db.activeSize = size
+	}
+	return nil
+}
+
+// replay applies the batches of a log file and returns the size of its
+// valid part. A bad record in the last file is taken to be torn by a
+// crash and cut off, anywhere else the log is corrupt.
+func (db *DB) replay(id uint32, f *os.File, last bool) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	offset := int64(0)
+	for offset < size {
+		b, offsets, length, err := readRecord(f, offset, size)
+		if err != nil {
+			if !last {
+				return 0, fmt.Errorf("log file %d is corrupt at offset %d: %w", id, offset, err)
+			}
+			if err := f.Truncate(offset); err != nil {
+				return 0, err
+			}
+			return offset, f.Sync()
+		}
+
+		db.apply(b, id, offset+recordHeaderSize, offsets)
+		offset += recordHeaderSize + int64(length)
+	}
+	return offset, nil
+}
+
+func readRecord(f *os.File, offset, size int64) (*Batch, []int, uint32, error) {
+	header := make([]byte, recordHeaderSize)
+	if offset+recordHeaderSize > size {
+		return nil, nil, 0, fmt.Errorf("record header is truncated")
+	}
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if offset+recordHeaderSize+int64(length) > size {
+		return nil, nil, 0, fmt.Errorf("record is truncated")
+	}
+	payload := make([]byte, length)
+	if _, err := f.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return nil, nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, nil, 0, fmt.Errorf("invalid record checksum")
+	}
+
+	b, offsets, err := decodeBatch(payload)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return b, offsets, length, nil
+}
+
+// fileIDs returns the ids of the log files in ascending order.
+func (db *DB) fileIDs() ([]uint32, error) {
+	matches, err := filepath.Glob(filepath.Join(db.dir, "wal-*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []uint32{}
+	for _, path := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "wal-"), ".log")
+		id, err := strconv.ParseUint(name, 10, 32)
+		if err != nil || filepath.Base(path) != fmt.Sprintf(logPattern, id) {
+			return nil, fmt.Errorf("unexpected log file %s", path)
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,143 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openDB(t *testing.T, dir string, opts Options) *DB {
+	db, err := Open(dir, opts)
+	assert.Nil(t, err)
+	return db
+}
+
+func put(t *testing.T, db *DB, kvs ...string) {
+	b := NewBatch()
+	for i := 0; i+1 < len(kvs); i += 2 {
+		b.Put([]byte(kvs[i]), []byte(kvs[i+1]))
+	}
+	assert.Nil(t, db.Write(b))
+}
+
+func assertValue(t *testing.T, db *DB, key, want string) {
+	v, err := db.Get([]byte(key))
+	assert.Nil(t, err)
+	assert.Equal(t, want, string(v))
+}
+
+func TestDB(t *testing.T) {
+	dir := t.TempDir()
+	db := openDB(t, dir, Options{})
+
+	put(t, db, "a", "1", "b", "2", "a", "3")
+	assertValue(t, db, "a", "3")
+	assertValue(t, db, "b", "2")
+
+	b := NewBatch()
+	b.Delete([]byte("b"))
+	b.Put([]byte("c"), []byte{})
+	assert.Nil(t, db.Write(b))
+
+	_, err := db.Get([]byte("b"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, db.Has([]byte("b")))
+	assert.True(t, db.Has([]byte("c")))
+	assert.Nil(t, db.Close())
+
+	db = openDB(t, dir, Options{})
+	defer db.Close()
+	assertValue(t, db, "a", "3")
+	assertValue(t, db, "c", "")
+	assert.False(t, db.Has([]byte("b")))
+}
+
+func TestDBIterate(t *testing.T) {
+	db := openDB(t, t.TempDir(), Options{})
+	defer db.Close()
+
+	put(t, db, "h/2", "b", "x/1", "y", "h/1", "a", "h/3", "c")
+
+	keys, values := []string{}, []string{}
+	err := db.Iterate([]byte("h/"), func(key, value []byte) error {
+		keys = append(keys, string(key))
+		values = append(values, string(value))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"h/1", "h/2", "h/3"}, keys)
+	assert.Equal(t, []string{"a", "b", "c"}, values)
+}
+
+func TestDBTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	db := openDB(t, dir, Options{})
+	put(t, db, "a", "1")
+	put(t, db, "b", "2", "c", "3")
+	assert.Nil(t, db.Close())
+
+	// cut the last batch in half
+	path := filepath.Join(dir, "wal-000000.log")
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(path, info.Size()-3))
+
+	db = openDB(t, dir, Options{})
+	assertValue(t, db, "a", "1")
+	assert.False(t, db.Has([]byte("b")))
+	assert.False(t, db.Has([]byte("c")))
+
+	// writing continues right after the last complete batch
+	put(t, db, "d", "4")
+	assert.Nil(t, db.Close())
+
+	db = openDB(t, dir, Options{})
+	defer db.Close()
+	assertValue(t, db, "a", "1")
+	assertValue(t, db, "d", "4")
+}
+
+func TestDBCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	db := openDB(t, dir, Options{MaxFileSize: 1})
+	put(t, db, "a", "1")
+	put(t, db, "b", "2")
+	assert.Nil(t, db.Close())
+
+	// damage to a file other than the last one can't be a torn write
+	path := filepath.Join(dir, "wal-000000.log")
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(path, data, 0o644))
+
+	_, err = Open(dir, Options{})
+	assert.NotNil(t, err)
+}
+
+func TestDBCompact(t *testing.T) {
+	dir := t.TempDir()
+	db := openDB(t, dir, Options{MaxFileSize: 64})
+	for i := 0; i < 10; i++ {
+		put(t, db, "a", string(rune('0'+i)), "b", "x")
+	}
+	b := NewBatch()
+	b.Delete([]byte("b"))
+	assert.Nil(t, db.Write(b))
+
+	assert.Nil(t, db.Compact())
+	assertValue(t, db, "a", "9")
+	assert.False(t, db.Has([]byte("b")))
+	assert.Nil(t, db.Close())
+
+	matches, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	assert.Nil(t, err)
+	assert.Len(t, matches, 1)
+
+	db = openDB(t, dir, Options{})
+	defer db.Close()
+	assertValue(t, db, "a", "9")
+	assert.False(t, db.Has([]byte("b")))
+}
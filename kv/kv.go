@@ -0,0 +1,126 @@
+// Package kv is an embedded key/value engine for chain data.
+//
+// Every change goes through a Batch, and a batch is applied atomically:
+// after a crash either all of its operations are visible or none are.
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned by Get for a key that isn't stored.
+var ErrNotFound = errors.New("key not found")
+
+type Store interface {
+	Get(key []byte) ([]byte, error)
+	Has(key []byte) bool
+	// Iterate calls fn with every key that starts with prefix and its
+	// value, in ascending key order. It stops at the first error returned
+	// by fn and returns it.
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+	// Write applies all operations of b atomically.
+	Write(b *Batch) error
+	Close() error
+}
+
+type opKind byte
+
+const (
+	opPut opKind = iota + 1
+	opDelete
+)
+
+type op struct {
+	kind  opKind
+	key   []byte
+	value []byte
+}
+
+// Batch collects operations to be applied together by Store.Write. Later
+// operations on a key win over earlier ones.
+type Batch struct {
+	ops []op
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) Put(key, value []byte) {
+	b.ops = append(b.ops, op{
+		kind:  opPut,
+		key:   append([]byte{}, key...),
+		value: append([]byte{}, value...),
+	})
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, op{
+		kind: opDelete,
+		key:  append([]byte{}, key...),
+	})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// encode serializes the operations of b and returns, for every operation,
+// the offset of its value in the encoding.
+func (b *Batch) encode() ([]byte, []int) {
+	buf := []byte{}
+	offsets := make([]int, len(b.ops))
+	for i, o := range b.ops {
+		buf = append(buf, byte(o.kind))
+		buf = appendUvarint(buf, uint64(len(o.key)))
+		buf = appendUvarint(buf, uint64(len(o.value)))
+		buf = append(buf, o.key...)
+		offsets[i] = len(buf)
+		buf = append(buf, o.value...)
+	}
+	return buf, offsets
+}
+
+// decodeBatch is the inverse of Batch.encode.
+func decodeBatch(buf []byte) (*Batch, []int, error) {
+	b := NewBatch()
+	offsets := []int{}
+	for pos := 0; pos < len(buf); {
+		kind := opKind(buf[pos])
+		if kind != opPut && kind != opDelete {
+			return nil, nil, fmt.Errorf("invalid operation %d at %d", kind, pos)
+		}
+		pos++
+
+		keyLen, n := binary.Uvarint(buf[pos:])
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("invalid key length at %d", pos)
+		}
+		pos += n
+		valueLen, n := binary.Uvarint(buf[pos:])
+		if n <= 0 {
+			return nil, nil, fmt.Errorf("invalid value length at %d", pos)
+		}
+		pos += n
+
+		if keyLen+valueLen > uint64(len(buf)-pos) {
+			return nil, nil, fmt.Errorf("operation at %d is truncated", pos)
+		}
+		key := buf[pos : pos+int(keyLen)]
+		pos += int(keyLen)
+		offsets = append(offsets, pos)
+		value := buf[pos : pos+int(valueLen)]
+		pos += int(valueLen)
+
+		b.ops = append(b.ops, op{kind: kind, key: key, value: value})
+	}
+	return b, offsets, nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	tmp := make([]byte, binary.MaxVarintLen64)
+	return append(buf, tmp[:binary.PutUvarint(tmp, v)]...)
+}
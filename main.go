@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"log"
 	"strconv"
@@ -9,11 +10,24 @@ import (
 
 	"github.com/hitenjain14/go-blockchain/core"
 	"github.com/hitenjain14/go-blockchain/crypto"
+	"github.com/hitenjain14/go-blockchain/kv"
 	"github.com/hitenjain14/go-blockchain/network"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	genesisFile := flag.String("genesis", "", "genesis file of the network, the stored or built-in genesis when empty")
+	dataDir := flag.String("datadir", "", "directory the local chain and its state are kept in, in memory when empty")
+	flag.Parse()
+
+	var genesis *core.Genesis
+	if *genesisFile != "" {
+		g, err := core.LoadGenesis(*genesisFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		genesis = g
+	}
 
 	trLocal := network.NewLocalTransport(network.NetAddr("local"))
 	trRemoteA := network.NewLocalTransport(network.NetAddr("remote_a"))
@@ -25,7 +39,7 @@ func main() {
 	trRemoteB.Connect(trRemoteC)
 	trRemoteA.Connect(trLocal)
 
-	initRemoteServers([]network.Transport{trRemoteA, trRemoteB, trRemoteC})
+	initRemoteServers([]network.Transport{trRemoteA, trRemoteB, trRemoteC}, genesis)
 	go func() {
 		for {
 			if err := sendTransaction(trRemoteA, trLocal.Addr()); err != nil {
@@ -37,15 +51,35 @@ func main() {
 
 	privKey := crypto.GeneratePrivateKey()
 
-	localServer := makeServer("local", trLocal, &privKey)
+	localServer := makeServer("local", trLocal, &privKey, genesis, openStorage(*dataDir))
 	localServer.Start()
 }
 
-func makeServer(id string, tr network.Transport, pk *crypto.PrivateKey) *network.Server {
+// openStorage returns a KVStore in dir, nil for a chain in memory when dir
+// is empty.
+func openStorage(dir string) core.Storage {
+	if dir == "" {
+		return nil
+	}
+
+	db, err := kv.Open(dir, kv.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := core.NewKVStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return store
+}
+
+func makeServer(id string, tr network.Transport, pk *crypto.PrivateKey, genesis *core.Genesis, store core.Storage) *network.Server {
 	opts := network.ServerOpts{
 		PrivateKey: pk,
 		ID:         id,
 		Transports: []network.Transport{tr},
+		Genesis:    genesis,
+		Storage:    store,
 	}
 
 	s, err := network.NewServer(opts)
@@ -55,10 +89,10 @@ func makeServer(id string, tr network.Transport, pk *crypto.PrivateKey) *network
 	return s
 }
 
-func initRemoteServers(trs []network.Transport) {
+func initRemoteServers(trs []network.Transport, genesis *core.Genesis) {
 	for i := 0; i < len(trs); i++ {
 		id := fmt.Sprintf("remote_%d", i)
-		s := makeServer(id, trs[i], nil)
+		s := makeServer(id, trs[i], nil, genesis, nil)
 		go s.Start()
 	}
 }
@@ -0,0 +1,109 @@
+package network
+
+import (
+	"sync"
+
+	"github.com/hitenjain14/go-blockchain/core"
+	"github.com/hitenjain14/go-blockchain/types"
+)
+
+// OrphanPool holds blocks whose parent isn't known yet, keyed by the hash
+// of that parent. When full, the oldest orphan is dropped.
+type OrphanPool struct {
+	lock      sync.Mutex
+	blocks    map[types.Hash]*core.Block
+	byParent  map[types.Hash][]types.Hash
+	order     []types.Hash
+	maxLength int
+}
+
+func NewOrphanPool(maxLength int) *OrphanPool {
+	return &OrphanPool{
+		blocks:    make(map[types.Hash]*core.Block),
+		byParent:  make(map[types.Hash][]types.Hash),
+		maxLength: maxLength,
+	}
+}
+
+// Add buffers b until its parent arrives. It reports false if b was
+// already in the pool.
+func (p *OrphanPool) Add(b *core.Block) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	hash := b.Hash(core.BlockHasher{})
+	if _, ok := p.blocks[hash]; ok {
+		return false
+	}
+
+	if len(p.order) == p.maxLength {
+		p.remove(p.order[0])
+	}
+
+	p.blocks[hash] = b
+	p.byParent[b.PrevBlockHash] = append(p.byParent[b.PrevBlockHash], hash)
+	p.order = append(p.order, hash)
+	return true
+}
+
+func (p *OrphanPool) Contains(hash types.Hash) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, ok := p.blocks[hash]
+	return ok
+}
+
+func (p *OrphanPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.blocks)
+}
+
+// TakeChildren removes the orphans whose parent is parent from the pool
+// and returns them, oldest first.
+func (p *OrphanPool) TakeChildren(parent types.Hash) []*core.Block {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// remove reuses the backing array of byParent, so work on a copy
+	hashes := append([]types.Hash{}, p.byParent[parent]...)
+	children := make([]*core.Block, 0, len(hashes))
+	for _, hash := range hashes {
+		children = append(children, p.blocks[hash])
+	}
+	for _, hash := range hashes {
+		p.remove(hash)
+	}
+	return children
+}
+
+// remove drops the orphan with hash. The caller must hold the lock.
+func (p *OrphanPool) remove(hash types.Hash) {
+	b, ok := p.blocks[hash]
+	if !ok {
+		return
+	}
+	delete(p.blocks, hash)
+
+	siblings := p.byParent[b.PrevBlockHash]
+	for i, h := range siblings {
+		if h == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, b.PrevBlockHash)
+	} else {
+		p.byParent[b.PrevBlockHash] = siblings
+	}
+
+	for i, h := range p.order {
+		if h == hash {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/hitenjain14/go-blockchain/core"
+	"github.com/hitenjain14/go-blockchain/crypto"
+	"github.com/hitenjain14/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// signedChain returns n signed blocks that follow the default genesis.
+func signedChain(t *testing.T, n int) []*core.Block {
+	privKey := crypto.GeneratePrivateKey()
+	prev := (&core.Genesis{}).Block().Header
+
+	blocks := []*core.Block{}
+	for i := 0; i < n; i++ {
+		b, err := core.NewBlockFromPrevHeader(core.DefaultChainConfig(), prev, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, b.Sign(privKey))
+		blocks = append(blocks, b)
+		prev = b.Header
+	}
+	return blocks
+}
+
+func TestOrphanPool(t *testing.T) {
+	p := NewOrphanPool(2)
+	blocks := signedChain(t, 4)
+
+	assert.True(t, p.Add(blocks[1]))
+	assert.False(t, p.Add(blocks[1]))
+	assert.True(t, p.Add(blocks[2]))
+	assert.Equal(t, 2, p.Len())
+
+	// the oldest orphan makes room
+	assert.True(t, p.Add(blocks[3]))
+	assert.Equal(t, 2, p.Len())
+	assert.False(t, p.Contains(blocks[1].Hash(core.BlockHasher{})))
+	assert.Empty(t, p.TakeChildren(blocks[0].Hash(core.BlockHasher{})))
+
+	children := p.TakeChildren(blocks[1].Hash(core.BlockHasher{}))
+	assert.Equal(t, []*core.Block{blocks[2]}, children)
+	assert.Equal(t, 1, p.Len())
+	assert.Empty(t, p.TakeChildren(types.RandomHash()))
+}
+
+func TestServerConnectsOrphans(t *testing.T) {
+	tra := NewLocalTransport("A")
+	trb := NewLocalTransport("B")
+	tra.Connect(trb)
+	trb.Connect(tra)
+
+	s, err := NewServer(ServerOpts{
+		ID:         "A",
+		Logger:     log.NewNopLogger(),
+		Transports: []Transport{tra},
+	})
+	assert.Nil(t, err)
+
+	blocks := signedChain(t, 3)
+	assert.Nil(t, s.processBlock(trb.Addr(), blocks[2]))
+	assert.Nil(t, s.processBlock(trb.Addr(), blocks[1]))
+	assert.Equal(t, uint32(0), s.chain.Height())
+	assert.Equal(t, 2, s.orphans.Len())
+
+	// only the parent of the first orphan is asked for, the second one's
+	// parent is in the pool already
+	rpc := <-trb.Consume()
+	msg, err := DefaultRPCDecodeFunc(rpc)
+	assert.Nil(t, err)
+	assert.Equal(t, &GetBlockMessage{Hash: blocks[1].Hash(core.BlockHasher{})}, msg.Data)
+
+	assert.Nil(t, s.processBlock(trb.Addr(), blocks[0]))
+	assert.Equal(t, uint32(3), s.chain.Height())
+	assert.Equal(t, 0, s.orphans.Len())
+}
+
+func TestServerRejectsInvalidOrphans(t *testing.T) {
+	tra := NewLocalTransport("A")
+	trb := NewLocalTransport("B")
+	tra.Connect(trb)
+	trb.Connect(tra)
+
+	s, err := NewServer(ServerOpts{
+		ID:         "A",
+		Logger:     log.NewNopLogger(),
+		Transports: []Transport{tra},
+	})
+	assert.Nil(t, err)
+
+	unsigned := signedChain(t, 2)[1]
+	unsigned.Signature = nil
+	assert.NotNil(t, s.processBlock(trb.Addr(), unsigned))
+
+	// neither buffered nor is its parent asked for
+	assert.Equal(t, 0, s.orphans.Len())
+	select {
+	case <-trb.Consume():
+		t.Fatal("parent of an invalid block was requested")
+	default:
+	}
+}
+
+func TestServerAnswersGetBlock(t *testing.T) {
+	tra := NewLocalTransport("A")
+	trb := NewLocalTransport("B")
+	tra.Connect(trb)
+	trb.Connect(tra)
+
+	s, err := NewServer(ServerOpts{
+		ID:         "A",
+		Logger:     log.NewNopLogger(),
+		Transports: []Transport{tra},
+	})
+	assert.Nil(t, err)
+
+	b := signedChain(t, 1)[0]
+	assert.Nil(t, s.chain.AddBlock(b))
+
+	assert.Nil(t, s.processGetBlock(trb.Addr(), &GetBlockMessage{Hash: b.Hash(core.BlockHasher{})}))
+	msg, err := DefaultRPCDecodeFunc(<-trb.Consume())
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(core.BlockHasher{}), msg.Data.(*core.Block).Hash(core.BlockHasher{}))
+
+	assert.NotNil(t, s.processGetBlock(trb.Addr(), &GetBlockMessage{Hash: types.RandomHash()}))
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 
 	"github.com/hitenjain14/go-blockchain/core"
+	"github.com/hitenjain14/go-blockchain/types"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,8 +16,15 @@ type MessageType byte
 const (
 	MessageTypeTx MessageType = iota
 	MessageTypeBlock
+	MessageTypeGetBlock
 )
 
+// GetBlockMessage asks a peer for the block with Hash, which it answers
+// with a block message.
+type GetBlockMessage struct {
+	Hash types.Hash
+}
+
 type RPC struct {
 	From    NetAddr
 	Payload io.Reader
@@ -80,6 +88,15 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 			From: rpc.From,
 			Data: block,
 		}, nil
+	case MessageTypeGetBlock:
+		getBlock := new(GetBlockMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getBlock); err != nil {
+			return nil, err
+		}
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: getBlock,
+		}, nil
 	default:
 		return nil, fmt.Errorf("invalid message header: %x", msg.Header)
 	}
@@ -2,6 +2,9 @@ package network
 
 import (
 	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
 	"os"
 	"time"
 
@@ -12,7 +15,13 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-const defaultBlockTime = 5 * time.Second
+const (
+	defaultBlockTime = 5 * time.Second
+
+	// defaultMaxOrphans is the number of blocks with an unknown parent
+	// kept while their parent is fetched.
+	defaultMaxOrphans = 100
+)
 
 type ServerOpts struct {
 	ID            string
@@ -22,12 +31,24 @@ type ServerOpts struct {
 	Transports    []Transport
 	PrivateKey    *crypto.PrivateKey
 	BlockTime     time.Duration
+	// Storage keeps the blocks of the chain, in memory when nil.
+	Storage core.Storage
+	// BlockCacheSize, when above zero, puts a cache of that many recently
+	// used blocks in front of Storage.
+	BlockCacheSize int
+	// Genesis is the genesis of the network the server joins, one without
+	// validators or allocations when nil.
+	Genesis *core.Genesis
+	// MaxOrphans is the number of blocks buffered until their parent
+	// arrives, defaultMaxOrphans when zero.
+	MaxOrphans int
 }
 
 type Server struct {
 	ServerOpts
 	isValidator bool
 	memPool     *TxPool
+	orphans     *OrphanPool
 	chain       *core.Blockchain
 	rpcCh       chan RPC
 	quitCh      chan struct{}
@@ -48,7 +69,21 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		opts.Logger = log.With(opts.Logger, "ID", opts.ID)
 	}
 
-	chain, err := core.NewBlockchain(opts.Logger, genesisBlock())
+	if opts.MaxOrphans == 0 {
+		opts.MaxOrphans = defaultMaxOrphans
+	}
+
+	if opts.Genesis == nil {
+		opts.Genesis = &core.Genesis{}
+	}
+
+	if opts.Storage == nil {
+		opts.Storage = core.NewMemoryStore()
+	} else if opts.BlockCacheSize > 0 {
+		opts.Storage = core.NewCachedStore(opts.Storage, opts.BlockCacheSize)
+	}
+
+	chain, err := core.NewBlockchainFromGenesis(opts.Logger, opts.Storage, opts.Genesis)
 	if err != nil {
 		return nil, err
 	}
@@ -58,6 +93,7 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		isValidator: opts.PrivateKey != nil,
 		chain:       chain,
 		memPool:     NewTxPool(1000),
+		orphans:     NewOrphanPool(opts.MaxOrphans),
 		rpcCh:       make(chan RPC),
 		quitCh:      make(chan struct{}, 1),
 	}
@@ -77,6 +113,8 @@ func NewServer(opts ServerOpts) (*Server, error) {
 func (s *Server) Start() {
 	s.initTransport()
 
+	s.Logger.Log("msg", "server starting", "genesis", s.chain.GenesisHash(), "height", s.chain.Height())
+
 free:
 	for {
 		select {
@@ -104,7 +142,9 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 	case *core.Transaction:
 		return s.processTransaction(t)
 	case *core.Block:
-		return s.processBlock(t)
+		return s.processBlock(msg.From, t)
+	case *GetBlockMessage:
+		return s.processGetBlock(msg.From, t)
 	}
 	return nil
 }
@@ -176,14 +216,94 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 	return nil
 }
 
-func (s *Server) processBlock(b *core.Block) error {
-	if err := s.chain.AddBlock(b); err != nil {
+func (s *Server) processBlock(from NetAddr, b *core.Block) error {
+	err := s.chain.AddBlock(b)
+	if errors.Is(err, core.ErrUnknownParent) {
+		return s.addOrphan(from, b)
+	}
+	if err != nil {
 		return err
 	}
 	go s.broadcastBlock(b)
+
+	s.connectOrphans(b.Hash(core.BlockHasher{}))
 	return nil
 }
 
+// addOrphan buffers b until its parent arrives and asks from, the peer b
+// came from, for that parent. AddBlock only reports a missing parent for
+// blocks that pass every other check it can make without the parent, so
+// invalid blocks never get here.
+func (s *Server) addOrphan(from NetAddr, b *core.Block) error {
+	if !s.orphans.Add(b) {
+		return nil
+	}
+
+	s.Logger.Log(
+		"msg", "adding orphan block",
+		"height", b.Height,
+		"hash", b.Hash(core.BlockHasher{}),
+		"parent", b.PrevBlockHash,
+	)
+
+	// the parent may be waiting in the pool itself, then it's its own
+	// missing parent that has been asked for already
+	if s.orphans.Contains(b.PrevBlockHash) {
+		return nil
+	}
+	return s.requestBlock(from, b.PrevBlockHash)
+}
+
+// connectOrphans adds the orphans that descend from the block with hash,
+// which was just added to the chain.
+func (s *Server) connectOrphans(hash types.Hash) {
+	queue := s.orphans.TakeChildren(hash)
+	for len(queue) > 0 {
+		b := queue[0]
+		queue = queue[1:]
+
+		if err := s.chain.AddBlock(b); err != nil {
+			s.Logger.Log("msg", "dropping orphan block", "hash", b.Hash(core.BlockHasher{}), "err", err)
+			continue
+		}
+		go s.broadcastBlock(b)
+
+		queue = append(queue, s.orphans.TakeChildren(b.Hash(core.BlockHasher{}))...)
+	}
+}
+
+func (s *Server) processGetBlock(from NetAddr, msg *GetBlockMessage) error {
+	b, err := s.chain.GetBlockByHash(msg.Hash)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := b.Encode(core.NewGobBlockEncoder(buf)); err != nil {
+		return err
+	}
+	return s.send(from, NewMessage(MessageTypeBlock, buf.Bytes()).Bytes())
+}
+
+func (s *Server) requestBlock(to NetAddr, hash types.Hash) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&GetBlockMessage{Hash: hash}); err != nil {
+		return err
+	}
+	return s.send(to, NewMessage(MessageTypeGetBlock, buf.Bytes()).Bytes())
+}
+
+// send delivers msg to the peer at addr over the first transport that
+// reaches it.
+func (s *Server) send(addr NetAddr, msg []byte) error {
+	for _, tr := range s.Transports {
+		if err := tr.SendMessage(addr, msg); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no transport reaches peer %s", addr)
+}
+
 func (s *Server) createNewBlock() error {
 	currentHeader, err := s.chain.GetHeader(s.chain.Height())
 	if err != nil {
@@ -193,7 +313,7 @@ func (s *Server) createNewBlock() error {
 	//For now we are using all the transactions in the mempool
 	txx := s.memPool.Pending()
 
-	block, err := core.NewBlockFromPrevHeader(currentHeader, txx)
+	block, err := core.NewBlockFromPrevHeader(s.chain.Config(), currentHeader, txx)
 	if err != nil {
 		return err
 	}
@@ -210,6 +330,7 @@ func (s *Server) createNewBlock() error {
 
 	go s.broadcastBlock(block)
 
+	s.connectOrphans(block.Hash(core.BlockHasher{}))
 	return nil
 }
 
@@ -224,16 +345,3 @@ func (s *Server) initTransport() {
 	}
 
 }
-
-func genesisBlock() *core.Block {
-
-	header := &core.Header{
-		Version:   1,
-		DataHash:  types.Hash{},
-		Timestamp: 000000,
-		Height:    0,
-	}
-
-	b, _ := core.NewBlock(header, nil)
-	return b
-}
@@ -34,3 +34,7 @@ func AddressFromBytes(b []byte) Address {
 
 	return Address(value)
 }
+
+func RandomAddress() Address {
+	return AddressFromBytes(RandomBytes(20))
+}